@@ -9,32 +9,71 @@
 package cli
 
 import (
+	"fmt"
 	"io/ioutil"
+	"net"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/spf13/cobra"
 	gc "github.com/untillpro/gochips"
-	"gopkg.in/yaml.v2"
 )
 
 const nodesFileName = "nodes.yml"
 
 var skipSSHConfiguration bool = false
 var argRootPassword string = ""
+var argSSHKey string = ""
 
 type user struct {
 	host, alias, userName, rootUserName string
+	node                                node
 }
 type node struct {
 	Host, Alias, DockerVersion string
 	SwarmMode                  string
 	Uname                      string
 	Traefik                    bool
+	HostKey                    string
+	Bastion                    []bastionHost `yaml:"bastion,omitempty"`
+	SSHUser                    string        `yaml:"sshuser,omitempty"` // Overrides ClusterUser
+	SSHPort                    int           `yaml:"sshport,omitempty"` // Overrides default port 22
+	SSHKey                     string        `yaml:"sshkey,omitempty"`  // Overrides cluster private key, public key is expected in SSHKey.pub
 }
 
-// AddNodes adds nodes to cluster configuration
-func AddNodes(nodesToAdd map[string]string, rootPassword string, skipSSH bool) {
+// parseNodeArg parses node given as <alias>=[<user>@]<host>[:<port>]
+func parseNodeArg(arg string) (node, error) {
+	var n node
+	aliasAndHost := strings.Split(arg, "=")
+	if len(aliasAndHost) != 2 {
+		return n, fmt.Errorf("wrong argument: `%s` must be <node name>=[<user>@]<node IP>[:<port>]", arg)
+	}
+	n.Alias = strings.TrimSpace(aliasAndHost[0])
+	n.Host = strings.TrimSpace(aliasAndHost[1])
+	if i := strings.LastIndex(n.Host, "@"); i >= 0 {
+		n.SSHUser = n.Host[:i]
+		n.Host = n.Host[i+1:]
+	}
+	// Port is optional, IPv6 address with port must be in brackets
+	if strings.HasPrefix(n.Host, "[") || strings.Count(n.Host, ":") == 1 {
+		host, port, err := net.SplitHostPort(n.Host)
+		if err != nil {
+			return n, fmt.Errorf("wrong argument: %s: %v", arg, err)
+		}
+		n.Host = host
+		if n.SSHPort, err = strconv.Atoi(port); err != nil || n.SSHPort <= 0 {
+			return n, fmt.Errorf("wrong argument: %s: wrong port %s", arg, port)
+		}
+	}
+	if len(n.Alias) == 0 || len(n.Host) == 0 || strings.Contains(arg, "@") && len(n.SSHUser) == 0 {
+		return n, fmt.Errorf("wrong argument: %s", arg)
+	}
+	return n, nil
+}
+
+// AddNodes adds nodes to cluster configuration, node SSH settings are kept as given
+func AddNodes(nodesToAdd []node, rootPassword string, skipSSH bool) {
 	gc.Info("Adding nodes", nodesToAdd)
 	gc.ExitIfFalse(len(nodesToAdd) > 0, "Nothing to add")
 
@@ -50,12 +89,16 @@ func AddNodes(nodesToAdd map[string]string, rootPassword string, skipSSH bool) {
 	gc.Verbose("ClusterName", clusterFile.ClusterName)
 	gc.Verbose("RootUserName", rootUserName)
 	var users []user
-	for name, IP := range nodesToAdd {
+	for _, n := range nodesToAdd {
 		var user user
-		user.alias = name
-		user.host = IP
+		user.alias = n.Alias
+		user.host = n.Host
 		user.rootUserName = rootUserName
 		user.userName = clusterFile.ClusterUserName
+		if len(n.SSHUser) > 0 {
+			user.userName = n.SSHUser
+		}
+		user.node = n
 		users = append(users, user)
 	}
 
@@ -63,7 +106,7 @@ func AddNodes(nodesToAdd map[string]string, rootPassword string, skipSSH bool) {
 
 	if !skipSSH {
 		for _, value := range users {
-			err := configHostToUseKeys(value, publicKeyFile, rootPassword)
+			err := configHostToUseKeys(clusterFile, value, publicKeyFile, rootPassword)
 			gc.ExitIfError(err, "Unable to add user for node: "+value.host)
 		}
 	}
@@ -71,7 +114,7 @@ func AddNodes(nodesToAdd map[string]string, rootPassword string, skipSSH bool) {
 	nodesChannel := make(chan interface{})
 	for _, value := range users {
 		go func(user user) {
-			client := getSSHClientInstance(user.userName, privateKeyFile)
+			client := withNodes(newExecutor(clusterFile, clusterFile.ClusterUserName, privateKeyFile, ""), clusterFile, user.node)
 			uname, err := client.Exec(user.host, "uname -a")
 			if err == nil {
 				err = configureFirewall(user.host, user.alias, client)
@@ -79,14 +122,17 @@ func AddNodes(nodesToAdd map[string]string, rootPassword string, skipSSH bool) {
 			if err == nil {
 				err = renameNode(user.host, user.alias, client)
 			}
+			var hostKey string
+			if err == nil {
+				hostKey, err = client.HostKey(user.host)
+			}
 			if err != nil {
 				nodesChannel <- err
 			} else {
-				nodeFromFunc := node{
-					Host:  user.host,
-					Alias: user.alias,
-					Uname: uname,
-				}
+				logWithPrefix(user.host, "Host key pinned "+hostKey)
+				nodeFromFunc := user.node
+				nodeFromFunc.Uname = uname
+				nodeFromFunc.HostKey = hostKey
 				nodesChannel <- nodeFromFunc
 			}
 		}(value)
@@ -105,10 +151,7 @@ func AddNodes(nodesToAdd map[string]string, rootPassword string, skipSSH bool) {
 		gc.Info(errMsg)
 	}
 	close(nodesChannel)
-	marshaledNode, err := yaml.Marshal(&nodesFromYaml)
-	gc.ExitIfError(err)
-	nodesFile := filepath.Join(getWorkingDir(), nodesFileName)
-	gc.ExitIfError(ioutil.WriteFile(nodesFile, marshaledNode, 0600))
+	writeNodesYml(nodesFromYaml)
 	gc.ExitIfFalse(len(errMsgs) == 0, "Failed to add some node(s)")
 	gc.Info("All nodes added")
 }
@@ -117,14 +160,19 @@ func add(cmd *cobra.Command, args []string) {
 	initCommand("add")
 	defer finitCommand()
 
-	checkSSHAgent()
-
 	// *************************************************
 	gc.Doing("Reading configuration")
 
 	readWorkingFileIfExists(swarmgoConfigFileName, "Config file not found, to create it run `swarmgo init`")
+	checkSSHAgent()
 
 	nodesFromYaml := getNodesFromYml(getWorkingDir())
+	if len(argSSHKey) > 0 {
+		gc.ExitIfFalse(FileExists(argSSHKey) && FileExists(argSSHKey+".pub"), "Private key and public key "+argSSHKey+".pub must exist")
+		var err error
+		argSSHKey, err = filepath.Abs(argSSHKey)
+		gc.ExitIfError(err)
+	}
 
 	// *************************************************
 	gc.Doing("Getting existing nodeNames and nodeIPs")
@@ -139,19 +187,15 @@ func add(cmd *cobra.Command, args []string) {
 	// *************************************************
 	gc.Doing("Calculating which nodes to add")
 
-	nodesToAdd := make(map[string]string)
+	nodesToAdd := make([]node, 0, len(args))
 
 	for _, arg := range args {
-		userAndAlias := strings.Split(arg, "=")
-		gc.ExitIfFalse(len(userAndAlias) == 2, "Wrong argument:`", arg, " ` must be <node name>=<node IP>")
-		userAndAlias[0] = strings.TrimSpace(userAndAlias[0])
-		userAndAlias[1] = strings.TrimSpace(userAndAlias[1])
+		nodeToAdd, err := parseNodeArg(arg)
+		gc.ExitIfError(err)
+		nodeToAdd.SSHKey = argSSHKey
 
-		gc.ExitIfFalse(len(userAndAlias[0]) > 0, "Wrong argument: ", arg)
-		gc.ExitIfFalse(len(userAndAlias[1]) > 0, "Wrong argument: ", arg)
-
-		nodeName := userAndAlias[0]
-		nodeIP := userAndAlias[1]
+		nodeName := nodeToAdd.Alias
+		nodeIP := nodeToAdd.Host
 
 		if value, ex := nodeNames[nodeName]; ex {
 			gc.Info("Name already configured:", nodeName, value)
@@ -162,7 +206,9 @@ func add(cmd *cobra.Command, args []string) {
 			gc.Info("IP already configured:", nodeIP, value)
 			continue
 		}
-		nodesToAdd[userAndAlias[0]] = userAndAlias[1]
+		nodeNames[nodeName] = nodeIP
+		nodeIPs[nodeIP] = nodeName
+		nodesToAdd = append(nodesToAdd, nodeToAdd)
 	}
 
 	AddNodes(nodesToAdd, argRootPassword, skipSSHConfiguration)
@@ -171,12 +217,12 @@ func add(cmd *cobra.Command, args []string) {
 var addNodeCmd = &cobra.Command{
 	Use:   "add",
 	Short: "Configure SSH access to nodes and add nodes to nodes.yml. Use -s option to skip SSH configuration",
-	Long:  `Use add <node name1>=<IP1> <node name2>=<IP2> ...`,
+	Long:  `Use add <node name1>=<IP1> <node name2>=<IP2> ..., SSH user and port can be specified per node as <node name>=<user>@<IP>:<port>`,
 	Args:  cobra.MinimumNArgs(1),
 	Run:   add,
 }
 
-func configHostToUseKeys(user user, publicKeyFile string, rootPass string) error {
+func configHostToUseKeys(clusterFile *clusterFile, user user, publicKeyFile string, rootPass string) error {
 
 	host := user.host
 	userName := user.userName
@@ -187,17 +233,28 @@ func configHostToUseKeys(user user, publicKeyFile string, rootPass string) error
 	scriptBytes, err := ioutil.ReadFile(scriptPath)
 	gc.ExitIfError(err, "Unable to read script from "+scriptPath)
 
+	if len(user.node.SSHKey) > 0 {
+		publicKeyFile = user.node.SSHKey + ".pub"
+	}
 	pemBytes, err := ioutil.ReadFile(publicKeyFile)
 	gc.ExitIfError(err, "Unable to read public key from "+publicKeyFile)
 	cmd := string(scriptBytes)
 	cmd = strings.ReplaceAll(cmd, "\r\n", "\n")
 
-	setupCmd := "echo '" + cmd + "' > ~/setup.sh && chmod 700 ~/setup.sh && ./setup.sh " + userName + " " + generateRandomString(32) + " \"" + string(pemBytes) + "\" && rm ~/setup.sh"
+	password := generateRandomString(32)
+	sudoMode := "nopasswd"
+	if clusterFile.SudoWithPassword {
+		password = getSudoPassword(clusterFile)
+		sudoMode = "passwd"
+	}
+
+	// Password is given on stdin, so it is neither in the command line nor in the log
+	setupCmd := "echo '" + cmd + "' > ~/setup.sh && chmod 700 ~/setup.sh && ./setup.sh " + userName + " \"" + string(pemBytes) + "\" " + sudoMode + " && rm ~/setup.sh"
 
-	client := getSSHClientInstance(rootUserName, "")
-	client.Password = rootPass
-	client.HideStdout = len(rootPass) > 0 // When password specified, no input expected from user
-	_, err = client.Exec(host, setupCmd)
+	client := withNodes(newExecutor(clusterFile, rootUserName, "", rootPass), clusterFile, user.node)
+	ctx, cancel := timeoutContext(defaultCommandTimeout)
+	defer cancel()
+	_, err = execOutput(client.RunInput(ctx, host, setupCmd, strings.NewReader(password+"\n")))
 	if err != nil {
 		return err
 	}
@@ -206,7 +263,7 @@ func configHostToUseKeys(user user, publicKeyFile string, rootPass string) error
 	return nil
 }
 
-func renameNode(host string, alias string, client *SSHClient) error {
+func renameNode(host string, alias string, client Executor) error {
 	commands := []SSHCommand{
 		SSHCommand{
 			cmd:   "sudo hostnamectl set-hostname " + alias,
@@ -216,15 +273,19 @@ func renameNode(host string, alias string, client *SSHClient) error {
 	return sshKeyAuthCmds(host, client, commands)
 }
 
-func configureFirewall(host string, alias string, client *SSHClient) error {
+func configureFirewall(host string, alias string, client Executor) error {
 	commands := []SSHCommand{
 		SSHCommand{
-			cmd:   "sudo apt-get update",
-			title: "Updating apt-get...",
+			cmd:     "sudo apt-get update",
+			title:   "Updating apt-get...",
+			timeout: aptCommandTimeout,
+			retry:   aptRetryPolicy,
 		},
 		SSHCommand{
-			cmd:   "sudo apt-get -y install ufw",
-			title: "Installing ufw",
+			cmd:     "sudo apt-get -y install ufw",
+			title:   "Installing ufw",
+			timeout: aptCommandTimeout,
+			retry:   aptRetryPolicy,
 		},
 		SSHCommand{
 			cmd:   "sudo ufw allow OpenSSH",
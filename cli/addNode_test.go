@@ -0,0 +1,35 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import "testing"
+
+func TestParseNodeArg(t *testing.T) {
+	valid := map[string]node{
+		"node1=10.0.0.1":                {Alias: "node1", Host: "10.0.0.1"},
+		" node1 = 10.0.0.1 ":            {Alias: "node1", Host: "10.0.0.1"},
+		"node1=ubuntu@10.0.0.1":         {Alias: "node1", Host: "10.0.0.1", SSHUser: "ubuntu"},
+		"node1=10.0.0.1:2222":           {Alias: "node1", Host: "10.0.0.1", SSHPort: 2222},
+		"node1=ubuntu@10.0.0.1:2222":    {Alias: "node1", Host: "10.0.0.1", SSHUser: "ubuntu", SSHPort: 2222},
+		"node1=fd00::1":                 {Alias: "node1", Host: "fd00::1"},
+		"node1=ubuntu@[fd00::1]:2222":   {Alias: "node1", Host: "fd00::1", SSHUser: "ubuntu", SSHPort: 2222},
+		"node1=node1.example.com:22022": {Alias: "node1", Host: "node1.example.com", SSHPort: 22022},
+	}
+	for arg, expected := range valid {
+		n, err := parseNodeArg(arg)
+		if err != nil || n.Alias != expected.Alias || n.Host != expected.Host || n.SSHUser != expected.SSHUser || n.SSHPort != expected.SSHPort {
+			t.Error("For:", arg, "expected:", expected, "got:", n, err)
+		}
+	}
+	for _, arg := range []string{"node1", "node1=", "=10.0.0.1", "node1=@10.0.0.1", "node1=10.0.0.1:port", "node1=10.0.0.1:0", "a=b=c"} {
+		if n, err := parseNodeArg(arg); err == nil {
+			t.Error("Error expected for:", arg, "got:", n)
+		}
+	}
+}
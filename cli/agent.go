@@ -10,43 +10,195 @@ package cli
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"strings"
+	"sync"
 
 	"github.com/spf13/cobra"
 	gc "github.com/untillpro/gochips"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
-var agentCmd = &cobra.Command{
-	Use:   "agent",
-	Short: "Starts ssh-agent and adds cluster key with ssh-add to current terminal session",
-	Long:  `For Shell terminals only. Use this command to input key password once and avoid typing it on further swarmgo commands in current temrinal session`,
-	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
+const sshAuthSockEnvVar = "SSH_AUTH_SOCK"
+
+var sshAgentConn = struct {
+	sync.Once
+	client agent.ExtendedAgent
+}{}
+
+func connectSSHAgent(socket string) (agent.ExtendedAgent, error) {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	return agent.NewClient(conn), nil
+}
 
-		output, err := exec.Command("ssh-agent").Output()
-		gc.ExitIfError(err, "Unable to run ssh-agent")
-		gc.Verbose("ssh-agent output", string(output))
-		sshAgentOut := output
+// getSSHAgent returns connection to the running ssh-agent or nil
+func getSSHAgent() agent.ExtendedAgent {
+	sshAgentConn.Do(func() {
+		socket := os.Getenv(sshAuthSockEnvVar)
+		if len(socket) == 0 {
+			return
+		}
+		client, err := connectSSHAgent(socket)
+		if err != nil {
+			gc.Info("Unable to connect to ssh-agent at "+socket+":", err)
+			return
+		}
+		sshAgentConn.client = client
+	})
+	return sshAgentConn.client
+}
+
+func describeAgentKeys(keys []*agent.Key) string {
+	if len(keys) == 0 {
+		return "no keys"
+	}
+	descriptions := make([]string, 0, len(keys))
+	for _, key := range keys {
+		descriptions = append(descriptions, ssh.FingerprintSHA256(key)+" "+key.Comment)
+	}
+	return strings.Join(descriptions, ", ")
+}
 
-		re := regexp.MustCompile("((?:SSH_AUTH_SOCK|SSH_AGENT_PID)=[^;]*)")
-		submatch := re.FindAllStringSubmatch(string(output), -1)
+// loadKeyToAgent adds private key to ssh-agent unless key with the same public key is loaded already.
+// Passphrase of encrypted key is asked once per command
+func loadKeyToAgent(sshAgent agent.Agent, publicKeyFile, privateKeyFile string) error {
+	_, fingerprint, err := readPublicKeyInfo(publicKeyFile)
+	if err != nil {
+		return fmt.Errorf("unable to read public key %s: %v", publicKeyFile, err)
+	}
+	keys, err := sshAgent.List()
+	if err != nil {
+		return fmt.Errorf("unable to list keys loaded to ssh-agent: %v", err)
+	}
+	for _, key := range keys {
+		if ssh.FingerprintSHA256(key) == fingerprint {
+			gc.Verbose("Cluster key is loaded to ssh-agent", fingerprint)
+			return nil
+		}
+	}
+	gc.Verbose("Cluster key "+fingerprint+" is not loaded to ssh-agent, loaded keys:", describeAgentKeys(keys))
 
-		gc.ExitIfFalse(submatch != nil && len(submatch) == 2 && len(submatch[0]) == 2 && len(submatch[1]) == 2,
-			"Expected SSH_AUTH_SOCK and SSH_AGENT_PID in ssh-agent output, see logs for more details")
+	key, err := parseRawPrivateKeyFile(privateKeyFile, true)
+	if err != nil {
+		return fmt.Errorf("unable to read private key %s: %v", privateKeyFile, err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return err
+	}
+	if ssh.FingerprintSHA256(signer.PublicKey()) != fingerprint {
+		return fmt.Errorf("private key %s doesn't match public key %s", privateKeyFile, publicKeyFile)
+	}
+	if err := sshAgent.Add(agent.AddedKey{PrivateKey: key, Comment: privateKeyFile}); err != nil {
+		return fmt.Errorf("ssh-agent refused to add the key: %v", err)
+	}
+	gc.Verbose("Cluster key loaded to ssh-agent", fingerprint)
+	return nil
+}
+
+// checkSSHAgent makes sure cluster key is loaded to ssh-agent, so passphrase is not asked again by further commands.
+// Commands work without ssh-agent as well, built-in client asks for the passphrase once per command then
+func checkSSHAgent() {
+	clusterFile := unmarshalClusterYml()
+	publicKeyFile, privateKeyFile := findSSHKeys(clusterFile)
+	sshAgent := getSSHAgent()
+	if sshAgent == nil {
+		gc.Info("ssh-agent isn't running, private key passphrase will be asked if needed. Run eval `swarmgo agent` to start it")
+		return
+	}
+	if !FileExists(privateKeyFile) {
+		gc.Verbose("Private key doesn't exist, nothing to load to ssh-agent", privateKeyFile)
+		return
+	}
+	if err := loadKeyToAgent(sshAgent, publicKeyFile, privateKeyFile); err != nil {
+		gc.Info("Cluster key is not loaded to ssh-agent:", err)
+	}
+}
+
+// startSSHAgent starts ssh-agent process and returns variables to be exported to the shell
+func startSSHAgent() (map[string]string, error) {
+	output, err := exec.Command("ssh-agent", "-s").Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to run ssh-agent: %v", err)
+	}
+	gc.Verbose("ssh-agent output", string(output))
+	vars := make(map[string]string)
+	re := regexp.MustCompile("(SSH_AUTH_SOCK|SSH_AGENT_PID)=([^;]*)")
+	for _, submatch := range re.FindAllStringSubmatch(string(output), -1) {
+		vars[submatch[1]] = submatch[2]
+	}
+	if len(vars[sshAuthSockEnvVar]) == 0 {
+		return nil, fmt.Errorf("expected %s in ssh-agent output, see logs for more details", sshAuthSockEnvVar)
+	}
+	return vars, nil
+}
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Starts ssh-agent if it isn't running and loads cluster key to it",
+	Long:  `For Shell terminals only, use as eval ` + "`swarmgo agent`" + `. Use this command to input key password once and avoid typing it on further swarmgo commands in current temrinal session`,
+	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
+		// Stdout is evaluated by shell
+		consoleOutput = os.Stderr
 
 		clusterFile := unmarshalClusterYml()
-		_, privateKeyFile := findSSHKeys(clusterFile)
+		publicKeyFile, privateKeyFile := findSSHKeys(clusterFile)
 		gc.ExitIfFalse(FileExists(privateKeyFile), "Private key file doesn't exist: "+privateKeyFile)
 		gc.Verbose("Private Key location:", privateKeyFile)
 
-		ecmd := exec.Command("env", submatch[0][1], submatch[1][1], "ssh-add", privateKeyFile)
-		ecmd.Stdout = os.Stdout
-		ecmd.Stdin = os.Stdin
-		gc.Verbose("Running", ecmd.Path, ecmd.Args)
-		err = ecmd.Run()
-		gc.ExitIfError(err, "Unable to run ssh-add")
+		sshAgent := getSSHAgent()
+		exports := make(map[string]string)
+		if sshAgent == nil {
+			var err error
+			exports, err = startSSHAgent()
+			gc.ExitIfError(err)
+			sshAgent, err = connectSSHAgent(exports[sshAuthSockEnvVar])
+			gc.ExitIfError(err, "Unable to connect to started ssh-agent")
+		}
+		gc.ExitIfError(loadKeyToAgent(sshAgent, publicKeyFile, privateKeyFile))
+		gc.Info("Cluster key is loaded to ssh-agent")
+
+		for _, name := range []string{sshAuthSockEnvVar, "SSH_AGENT_PID"} {
+			if value, ok := exports[name]; ok {
+				fmt.Printf("%[1]s=%[2]s; export %[1]s;\n", name, value)
+			}
+		}
+	}),
+}
+
+var agentListCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "Lists keys loaded to ssh-agent",
+	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
+		sshAgent := getSSHAgent()
+		gc.ExitIfFalse(sshAgent != nil, "ssh-agent isn't running, run eval `swarmgo agent` to start it")
+		keys, err := sshAgent.List()
+		gc.ExitIfError(err, "Unable to list keys loaded to ssh-agent")
 
-		fmt.Printf(string(sshAgentOut))
+		clusterFingerprint := ""
+		if FileExists(filepath.Join(getWorkingDir(), swarmgoConfigFileName)) {
+			publicKeyFile, _ := findSSHKeys(unmarshalClusterYml())
+			_, clusterFingerprint, _ = readPublicKeyInfo(publicKeyFile)
+		}
+		for _, key := range keys {
+			mark := ""
+			if ssh.FingerprintSHA256(key) == clusterFingerprint {
+				mark = " (cluster key)"
+			}
+			fmt.Printf("%s %s %s%s\n", key.Type(), ssh.FingerprintSHA256(key), key.Comment, mark)
+		}
+		if len(keys) == 0 {
+			gc.Info("No keys loaded to ssh-agent")
+		} else if len(clusterFingerprint) > 0 && !strings.Contains(describeAgentKeys(keys), clusterFingerprint) {
+			gc.Info("Cluster key " + clusterFingerprint + " is not loaded, run eval `swarmgo agent` to load it")
+		}
 	}),
 }
@@ -0,0 +1,54 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestLoadKeyToAgent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarmgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	privateKeyFile := filepath.Join(dir, "cluster")
+	fingerprint, err := generateKeysAndWriteToFile(keyTypeEd25519, "test", privateKeyFile, privateKeyFile+".pub", "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKeyFile := filepath.Join(dir, "other")
+	if _, err := generateKeysAndWriteToFile(keyTypeEd25519, "test", otherKeyFile, otherKeyFile+".pub", ""); err != nil {
+		t.Fatal(err)
+	}
+	rememberKeyPassphrase(privateKeyFile, "pass")
+
+	keyring := agent.NewKeyring()
+	for i := 0; i < 2; i++ {
+		if err := loadKeyToAgent(keyring, privateKeyFile+".pub", privateKeyFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys, err := keyring.List()
+	if err != nil || len(keys) != 1 || keys[0].Comment != privateKeyFile || describeAgentKeys(keys) != fingerprint+" "+privateKeyFile {
+		t.Error("Cluster key must be loaded once, got:", describeAgentKeys(keys), err)
+	}
+
+	if err := loadKeyToAgent(keyring, privateKeyFile+".pub", otherKeyFile); err != nil {
+		t.Error("Loaded key must not be read again, got:", err)
+	}
+	if err := loadKeyToAgent(agent.NewKeyring(), privateKeyFile+".pub", otherKeyFile); err == nil {
+		t.Error("Mismatch of private and public keys must be reported")
+	}
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// bastionHostKeysFileName keeps host keys of bastions pinned on first use, next to nodes.yml
+const bastionHostKeysFileName = "bastion-hostkeys.yml"
+
+// bastionHost is a jump host used to reach nodes on private networks
+type bastionHost struct {
+	Host       string `yaml:"Host"`
+	Port       int    `yaml:"Port,omitempty"`
+	User       string `yaml:"User,omitempty"`
+	PrivateKey string `yaml:"PrivateKey,omitempty"`
+	HostKey    string `yaml:"HostKey,omitempty"`
+}
+
+// withBastionDefaults returns bastion hosts with empty user and key replaced by cluster ones
+func withBastionDefaults(bastion []bastionHost, userName, privateKeyFile string) []bastionHost {
+	res := make([]bastionHost, len(bastion))
+	for i, b := range bastion {
+		if len(b.User) == 0 {
+			b.User = userName
+		}
+		if len(b.PrivateKey) == 0 {
+			b.PrivateKey = privateKeyFile
+		}
+		if b.Port == 0 {
+			b.Port = defaultSSHPort
+		}
+		res[i] = b
+	}
+	return res
+}
+
+// bastionTargets converts chain of bastion hosts to targets, each target is reached through the previous ones
+func bastionTargets(bastion []bastionHost) []*sshTarget {
+	res := make([]*sshTarget, 0, len(bastion))
+	for _, b := range bastion {
+		res = append(res, &sshTarget{
+			host:           b.Host,
+			port:           b.Port,
+			user:           b.User,
+			privateKeyFile: b.PrivateKey,
+			hostKey:        b.HostKey,
+			jumps:          append([]*sshTarget{}, res...),
+		})
+	}
+	return res
+}
+
+// bastionHostKeyStore keeps fingerprints of bastion host keys pinned on first use by host:port.
+// HostKey given in swarmgo-config.yml or nodes.yml takes precedence
+type bastionHostKeyStore struct {
+	file string
+}
+
+// bastionHostKeysLock serializes access to bastion-hostkeys.yml, several clients may be used by one command
+var bastionHostKeysLock sync.Mutex
+
+func (s *bastionHostKeyStore) read() (map[string]string, error) {
+	res := make(map[string]string)
+	content, err := ioutil.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return res, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(content, &res); err != nil {
+		return nil, fmt.Errorf("unable to read %s: %v", bastionHostKeysFileName, err)
+	}
+	return res, nil
+}
+
+// get returns pinned fingerprint, empty if bastion was never reached
+func (s *bastionHostKeyStore) get(host string, port int) (string, error) {
+	bastionHostKeysLock.Lock()
+	defer bastionHostKeysLock.Unlock()
+	keys, err := s.read()
+	if err != nil {
+		return "", err
+	}
+	return keys[net.JoinHostPort(host, strconv.Itoa(port))], nil
+}
+
+// pin saves fingerprint of the key seen first time, key pinned meanwhile by another connection must match
+func (s *bastionHostKeyStore) pin(host string, port int, fingerprint string) error {
+	return s.save(host, port, fingerprint, false)
+}
+
+// repin replaces pinned fingerprint, used by `swarmgo hostkeys`
+func (s *bastionHostKeyStore) repin(host string, port int, fingerprint string) error {
+	return s.save(host, port, fingerprint, true)
+}
+
+func (s *bastionHostKeyStore) save(host string, port int, fingerprint string, replace bool) error {
+	bastionHostKeysLock.Lock()
+	defer bastionHostKeysLock.Unlock()
+	keys, err := s.read()
+	if err != nil {
+		return err
+	}
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	if pinned, ok := keys[address]; ok && !replace {
+		if pinned != fingerprint {
+			return fmt.Errorf("host key mismatch for bastion %s: %s is pinned in %s, but %s is offered", address, pinned, bastionHostKeysFileName, fingerprint)
+		}
+		return nil
+	}
+	keys[address] = fingerprint
+	content, err := yaml.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.file, content, 0600)
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestBastionHostKeyPinning(t *testing.T) {
+	store := &bastionHostKeyStore{filepath.Join(t.TempDir(), bastionHostKeysFileName)}
+	client := Client("cluster", "/keys/cluster")
+	client.bastionHostKeys = store
+	client.Bastion = withBastionDefaults([]bastionHost{{Host: "bastion1"}, {Host: "bastion2", HostKey: "SHA256:configured"}}, "cluster", "/keys/cluster")
+
+	target := client.target("10.0.0.1")
+	first, second := target.jumps[0], target.jumps[1]
+	if len(first.hostKey) > 0 || first.pinHostKey == nil {
+		t.Fatal("Bastion never reached must be pinned on first use:", first)
+	}
+	if second.hostKey != "SHA256:configured" || second.pinHostKey != nil {
+		t.Error("Configured key must be used:", second)
+	}
+
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pinnedHostKeyCallback(first)("bastion1:22", nil, key); err != nil {
+		t.Fatal(err)
+	}
+	target = client.target("10.0.0.1")
+	if target.jumps[0].hostKey != ssh.FingerprintSHA256(key) || target.jumps[0].pinHostKey != nil {
+		t.Error("Key seen first must be pinned:", target.jumps[0])
+	}
+
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ssh.NewPublicKey(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pinnedHostKeyCallback(target.jumps[0])("bastion1:22", nil, otherKey); err == nil || !strings.Contains(err.Error(), "host key mismatch") {
+		t.Error("Another key must be refused:", err)
+	}
+	// Connection made by another client which read the store before the key was pinned
+	if err := pinnedHostKeyCallback(first)("bastion1:22", nil, otherKey); err == nil || !strings.Contains(err.Error(), "host key mismatch") {
+		t.Error("Another key must be refused on first use as well:", err)
+	}
+
+	if err := store.repin("bastion1", defaultSSHPort, ssh.FingerprintSHA256(otherKey)); err != nil {
+		t.Fatal(err)
+	}
+	if pinned, _ := store.get("bastion1", defaultSSHPort); pinned != ssh.FingerprintSHA256(otherKey) {
+		t.Error("Key must be re-pinned:", pinned)
+	}
+}
@@ -34,18 +34,23 @@ const (
 	swarmgoCliFolder      = "cli"
 	swarmgoConfigFileName = "swarmgo-config.yml"
 	swarmgoConfigPerms    = 0644
+
+	defaultCommandTimeout = 10 * time.Minute
+	aptCommandTimeout     = 30 * time.Minute
 )
 
 // SSHCommand represents single SSH command
 type SSHCommand struct {
-	cmd   string
-	title string
-	cmd1  string
-	cmd2  string
-	cmd3  string
-	cmd4  string
-	cmd5  string
-	cmd6  string
+	cmd     string
+	title   string
+	timeout time.Duration // Timeout for each command attempt, defaultCommandTimeout is used if zero
+	retry   *retryPolicy  // defaultRetryPolicy is used if nil
+	cmd1    string
+	cmd2    string
+	cmd3    string
+	cmd4    string
+	cmd5    string
+	cmd6    string
 }
 
 // SSHBatch is a set of commands
@@ -60,72 +65,102 @@ func getTempDir() string {
 	return tmp
 }
 
-func getSSHClientInstance(userName, privateKeyFile string) *SSHClient {
+func getSSHClientInstance(file *clusterFile, userName, privateKeyFile string) *SSHClient {
 	client := Client(userName, privateKeyFile)
 	client.Verbose = true
 	client.StrictHostKeyChecking = false
 	client.HideStdout = true
+	client.Timeout = defaultCommandTimeout
 	client.TempDir = getTempDir()
+
+	client.bastionHostKeys = &bastionHostKeyStore{filepath.Join(getWorkingDir(), bastionHostKeysFileName)}
+	client.nodeHostKeys = &nodeHostKeyStore{filepath.Join(getWorkingDir(), nodesFileName)}
+	client.Bastion = withBastionDefaults(file.Bastion, file.ClusterUserName, bastionKey(file, userName, privateKeyFile))
+	client.Nodes = make(map[string]node)
+	client.setNodes(file, getNodesFromYml(getWorkingDir()))
 	return client
 }
 
-func getSSHClient(file *clusterFile) *SSHClient {
+// bastionKey returns key used for bastion hosts without own key. Cluster user reaches them by the key of the client,
+// e.g. client with the new key during key rotation must not pass bastion by the old one
+func bastionKey(file *clusterFile, userName, privateKeyFile string) string {
+	if userName == file.ClusterUserName && len(privateKeyFile) > 0 {
+		return privateKeyFile
+	}
+	_, clusterPrivateKey := findSSHKeys(file)
+	return clusterPrivateKey
+}
+
+// setNodes makes client use settings of given nodes, SSH user and key overrides are applied to the cluster user only
+func (c *SSHClient) setNodes(file *clusterFile, nodes []node) {
+	key := bastionKey(file, c.User, c.PrivateKeyFile)
+	for _, node := range nodes {
+		node.Bastion = withBastionDefaults(node.Bastion, file.ClusterUserName, key)
+		if c.User != file.ClusterUserName {
+			node.SSHUser = ""
+			node.SSHKey = ""
+		}
+		c.Nodes[node.Host] = node
+	}
+}
+
+func getSSHClient(file *clusterFile) Executor {
 	_, privateKey := findSSHKeys(file)
-	return getSSHClientInstance(file.ClusterUserName, privateKey)
+	return newExecutor(file, file.ClusterUserName, privateKey, "")
 }
 
-func sshKeyAuthCmds(host string, client *SSHClient, commands []SSHCommand) error {
+func sshKeyAuthCmds(host string, client Executor, commands []SSHCommand) error {
 	for _, cmd := range commands {
 		logWithPrefix(host, cmd.title)
-		_, error := client.Exec(host, cmd.cmd)
-		if error != nil {
-			return error
-		}
-		if len(cmd.cmd1) > 0 {
-			_, error := client.Exec(host, cmd.cmd1)
-			if error != nil {
-				return error
-			}
+		err := execStep(host, client, cmd)
+		if err != nil {
+			return err
 		}
-		if len(cmd.cmd2) > 0 {
-			_, error := client.Exec(host, cmd.cmd2)
-			if error != nil {
-				return error
-			}
-		}
-		if len(cmd.cmd3) > 0 {
-			_, error := client.Exec(host, cmd.cmd3)
-			if error != nil {
-				return error
-			}
-		}
-		if len(cmd.cmd4) > 0 {
-			_, error := client.Exec(host, cmd.cmd4)
-			if error != nil {
-				return error
-			}
-		}
-		if len(cmd.cmd5) > 0 {
-			_, error := client.Exec(host, cmd.cmd5)
-			if error != nil {
-				return error
-			}
+	}
+	return nil
+}
+
+// stepError returns error of the failed step, failure to run it is returned as transportError
+func stepError(res *ExecResult, err error) error {
+	if err != nil {
+		_, err = execOutput(res, err)
+		return &transportError{err}
+	}
+	_, err = execOutput(res, nil)
+	return err
+}
+
+func execStep(host string, client Executor, cmd SSHCommand) error {
+	timeout := cmd.timeout
+	if timeout == 0 {
+		timeout = defaultCommandTimeout
+	}
+	retry := cmd.retry
+	if retry == nil {
+		retry = defaultRetryPolicy
+	}
+	for _, c := range []string{cmd.cmd, cmd.cmd1, cmd.cmd2, cmd.cmd3, cmd.cmd4, cmd.cmd5, cmd.cmd6} {
+		if len(c) == 0 {
+			continue
 		}
-		if len(cmd.cmd6) > 0 {
-			_, error := client.Exec(host, cmd.cmd6)
-			if error != nil {
-				return error
-			}
+		err := retry.do(host, func() error {
+			ctx, cancel := timeoutContext(timeout)
+			defer cancel()
+			return stepError(client.RunContext(ctx, host, c))
+		})
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
 func readPasswordPrompt(prompt string) string {
-	fmt.Print(prompt + ":")
+	// Prompt goes to stderr, so it is visible when output is captured, e.g. by eval
+	fmt.Fprint(os.Stderr, prompt+":")
 	password, err := terminal.ReadPassword(int(syscall.Stdin))
 	gc.ExitIfError(err)
-	fmt.Println("")
+	fmt.Fprintln(os.Stderr, "")
 	return string(password)
 }
 
@@ -177,7 +212,7 @@ func contains(slice []string, find string) bool {
 
 func containsNode(slice []node, find node) bool {
 	for _, a := range slice {
-		if a == find {
+		if a.Host == find.Host {
 			return true
 		}
 	}
@@ -226,6 +261,9 @@ func findSSHKeys(clusterFile *clusterFile) (string, string) {
 			publicKeyFile = appendChildToExecutablePath(publicKeyFile)
 			privateKeyFile = appendChildToExecutablePath(privateKeyFile)
 		}
+		if dir, ok := contextDir(); ok {
+			publicKeyFile, privateKeyFile = contextSSHKeys(dir, clusterFile.ClusterName, publicKeyFile, privateKeyFile)
+		}
 	}
 	return publicKeyFile, privateKeyFile
 }
@@ -275,15 +313,34 @@ func inputFuncForHosts(hostsWithNumbers map[int]string) string {
 
 func unmarshalClusterYml() *clusterFile {
 	clusterFileEntry := readWorkingFileIfExists(swarmgoConfigFileName, "You should create swarmgo-config.yml")
+	warnIfMigrationRequired(clusterFileEntry)
+	overrides := readClusterConfigOverrides()
+	exitIfClusterConfigInvalid(clusterFileEntry, overrides)
 	clusterFileStruct := clusterFile{}
 	gc.ExitIfError(yaml.Unmarshal(clusterFileEntry, &clusterFileStruct))
+	gc.ExitIfError(applyConfigOverrides(&clusterFileStruct, overrides))
 	return &clusterFileStruct
 }
 
+// marshalClusterYml writes config, values given by environment variables and --set options are not written
+// unmarshalClusterYmlForUpdate is used by commands which write swarmgo-config.yml, outdated files are migrated first,
+// so settings added by migration are not lost when the config is written back
+func unmarshalClusterYmlForUpdate() *clusterFile {
+	readWorkingFileIfExists(swarmgoConfigFileName, "You should create swarmgo-config.yml")
+	migrateClusterDir(false)
+	return unmarshalClusterYml()
+}
+
 func marshalClusterYml(config *clusterFile) {
+	path := filepath.Join(getWorkingDir(), swarmgoConfigFileName)
+	fileConfig := clusterFile{}
+	if content, err := ioutil.ReadFile(path); err == nil {
+		gc.ExitIfError(yaml.Unmarshal(content, &fileConfig))
+	}
+	config, err := withoutConfigOverrides(config, fileConfig, readClusterConfigOverrides())
+	gc.ExitIfError(err)
 	marshaledNode, err := yaml.Marshal(config)
 	gc.ExitIfError(err)
-	path := filepath.Join(getWorkingDir(), swarmgoConfigFileName)
 	gc.ExitIfError(ioutil.WriteFile(path, marshaledNode, swarmgoConfigPerms))
 }
 
@@ -322,6 +379,10 @@ func getWorkingDir() string {
 			workingDirReported = true
 		}
 	}()
+	if dir, ok := contextDir(); ok {
+		res = dir
+		return res
+	}
 	nodesFile := filepath.Join(src, nodesFileName)
 	if FileExists(nodesFile) {
 		return res
@@ -374,10 +435,13 @@ func ParseDockerVersion(stdout string) string {
 
 func checkSwarmNodeLabelTrue(clusterFile *clusterFile, firstEntry *entry, label string, onlyOne bool) {
 	client := getSSHClient(clusterFile)
-	g := client.ExecOrExit(firstEntry.node.Host,
-		"sudo docker node ls -q | xargs sudo docker node inspect   -f '{{ .Spec.Labels }}' | grep -c "+label+":true || true")
-	count, err := strconv.ParseInt(g, 10, 32)
-	gc.ExitIfError(err, "Unexpected output from command", g)
+	res, err := client.Run(firstEntry.node.Host,
+		"sudo docker node ls -q | xargs sudo docker node inspect   -f '{{ .Spec.Labels }}' | grep -c "+label+":true")
+	gc.ExitIfError(err)
+	// grep exits with 1 if nothing found
+	gc.ExitIfFalse(res.ExitCode <= 1, "Unable to read node labels: "+res.Stderr)
+	count, err := strconv.ParseInt(res.Output(), 10, 32)
+	gc.ExitIfError(err, "Unexpected output from command", res.Stdout)
 	gc.ExitIfFalse(count > 0, "Node labeled as ["+label+"=true] not found! Assign node label using \"swarmgo label add [NODE] "+label+"=true\" command.")
 	if onlyOne {
 		gc.ExitIfFalse(count == 1, "Multiple nodes labeled as ["+label+"] found! There must be only one node for "+label+".")
@@ -0,0 +1,212 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	gc "github.com/untillpro/gochips"
+	"gopkg.in/yaml.v2"
+)
+
+const configEnvPrefix = "SWARMGO_"
+
+var configSetArgs []string
+var configShowOriginArg bool
+
+// secretConfigKeys are not shown by `config show`
+var secretConfigKeys = map[string]bool{
+	"webhookurl":            true,
+	"grafanapassword":       true,
+	"prometheusbasicauth":   true,
+	"alertmanagerbasicauth": true,
+	"traefikbasicauth":      true,
+	"kibanacreds":           true,
+}
+
+// configField is a clusterFile field with its yaml key
+type configField struct {
+	Key   string
+	Index int
+}
+
+// configOverride is a value given by SWARMGO_<FIELD> environment variable or --set option
+type configOverride struct {
+	Key    string
+	Value  string
+	Origin string
+}
+
+// clusterFileFields returns fields in declaration order, fields without yaml tag have lowercased names as keys
+func clusterFileFields() []configField {
+	t := reflect.TypeOf(clusterFile{})
+	res := make([]configField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if len(key) == 0 {
+			key = strings.ToLower(t.Field(i).Name)
+		}
+		res = append(res, configField{key, i})
+	}
+	return res
+}
+
+// configEnvVar returns environment variable of the key, empty if variable is used for another purpose
+func configEnvVar(key string) string {
+	name := configEnvPrefix + strings.ToUpper(key)
+	if name == clusterEnvVar {
+		return "" // Selects cluster context, Cluster can be given by --set only
+	}
+	return name
+}
+
+// configOverrides collects overrides from environment and --set options, --set wins
+func configOverrides(environ []string, sets []string) ([]configOverride, error) {
+	res := make([]configOverride, 0)
+	env := make(map[string]string)
+	for _, e := range environ {
+		if name, value, ok := strings.Cut(e, "="); ok {
+			env[name] = value
+		}
+	}
+	for _, f := range clusterFileFields() {
+		if name := configEnvVar(f.Key); len(name) > 0 {
+			if value, ok := env[name]; ok {
+				res = append(res, configOverride{f.Key, value, name})
+			}
+		}
+	}
+	for _, set := range sets {
+		key, value, ok := strings.Cut(set, "=")
+		if !ok {
+			return nil, fmt.Errorf("wrong --set %s, must be Key=Value", set)
+		}
+		found := false
+		for _, f := range clusterFileFields() {
+			if strings.EqualFold(f.Key, key) {
+				res = append(res, configOverride{f.Key, value, "--set"})
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("wrong --set %s, unknown key %s", set, key)
+		}
+	}
+	return res, nil
+}
+
+// applyConfigOverrides sets overridden fields, non-string values are parsed as YAML, e.g. true or [{Host: bastion}]
+func applyConfigOverrides(config *clusterFile, overrides []configOverride) error {
+	v := reflect.ValueOf(config).Elem()
+	for _, o := range overrides {
+		for _, f := range clusterFileFields() {
+			if f.Key != o.Key {
+				continue
+			}
+			field := v.Field(f.Index)
+			if field.Kind() == reflect.String {
+				field.SetString(o.Value)
+				continue
+			}
+			value := reflect.New(field.Type())
+			if err := yaml.UnmarshalStrict([]byte(o.Value), value.Interface()); err != nil {
+				return fmt.Errorf("wrong value of %s given by %s: %v", o.Key, o.Origin, err)
+			}
+			field.Set(value.Elem())
+		}
+	}
+	return nil
+}
+
+func readClusterConfigOverrides() []configOverride {
+	overrides, err := configOverrides(os.Environ(), configSetArgs)
+	gc.ExitIfError(err)
+	return overrides
+}
+
+// withoutConfigOverrides returns config to be written to swarmgo-config.yml, overridden values which weren't changed
+// by the command are replaced by values from the file, so secrets given by environment don't reach the disk
+func withoutConfigOverrides(config *clusterFile, fileConfig clusterFile, overrides []configOverride) (*clusterFile, error) {
+	effective := fileConfig
+	if err := applyConfigOverrides(&effective, overrides); err != nil {
+		return nil, err
+	}
+	res := *config
+	file, eff, v := reflect.ValueOf(fileConfig), reflect.ValueOf(effective), reflect.ValueOf(&res).Elem()
+	for _, o := range overrides {
+		for _, f := range clusterFileFields() {
+			if f.Key == o.Key && reflect.DeepEqual(v.Field(f.Index).Interface(), eff.Field(f.Index).Interface()) {
+				v.Field(f.Index).Set(file.Field(f.Index))
+			}
+		}
+	}
+	return &res, nil
+}
+
+// configFieldValue formats value for `config show`, lists and maps are shown in JSON which is accepted by --set
+func configFieldValue(value reflect.Value) string {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Map:
+		if value.Len() == 0 {
+			return ""
+		}
+		b, err := json.Marshal(value.Interface())
+		gc.ExitIfError(err)
+		return string(b)
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show [--origin]",
+	Short: "Shows effective configuration, secrets are masked",
+	Long: `Shows values from swarmgo-config.yml with ` + configEnvPrefix + `<KEY> environment variables and --set Key=Value options applied.
+Use --origin to show where every value comes from`,
+	Run: func(cmd *cobra.Command, args []string) {
+		clusterFile := unmarshalClusterYml()
+		content, err := ioutil.ReadFile(filepath.Join(getWorkingDir(), swarmgoConfigFileName))
+		gc.ExitIfError(err)
+		lines := topLevelKeyLines(content)
+		origins := make(map[string]string)
+		for _, o := range readClusterConfigOverrides() {
+			origins[o.Key] = o.Origin
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		v := reflect.ValueOf(clusterFile).Elem()
+		for _, f := range clusterFileFields() {
+			value := configFieldValue(v.Field(f.Index))
+			if secretConfigKeys[f.Key] && len(value) > 0 {
+				value = maskedValue
+			}
+			if !configShowOriginArg {
+				fmt.Fprintf(w, "%s\t%s\n", f.Key, value)
+				continue
+			}
+			origin, ok := origins[f.Key]
+			switch {
+			case ok:
+			case lines[f.Key] > 0:
+				origin = fmt.Sprintf("%s:%d", swarmgoConfigFileName, lines[f.Key])
+			default:
+				origin = "default"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", f.Key, value, origin)
+		}
+		w.Flush()
+	},
+}
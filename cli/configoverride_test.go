@@ -0,0 +1,63 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestConfigOverrides(t *testing.T) {
+	environ := []string{
+		"SWARMGO_CLUSTER=prod", // Selects context
+		"SWARMGO_DOMAIN=env.example.com",
+		"SWARMGO_WEBHOOKURL=https://hooks.slack.com/services/x",
+		"SWARMGO_RECORD=imlucky.yml",
+	}
+	overrides, err := configOverrides(environ, []string{"domain=set.example.com", "ACMEEnabled=true", "Bastion=[{Host: bastion, Port: 2222}]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[{Domain env.example.com SWARMGO_DOMAIN} {webhookurl https://hooks.slack.com/services/x SWARMGO_WEBHOOKURL} " +
+		"{Domain set.example.com --set} {ACMEEnabled true --set} {Bastion [{Host: bastion, Port: 2222}] --set}]"
+	if fmt.Sprint(overrides) != expected {
+		t.Error("Unexpected overrides:", overrides)
+	}
+	if _, err := configOverrides(nil, []string{"Domian=example.com"}); err == nil {
+		t.Error("Unknown key must be reported")
+	}
+
+	fileConfig := clusterFile{ClusterName: "test", Domain: "file.example.com", Email: "admin@example.com"}
+	config := fileConfig
+	if err := applyConfigOverrides(&config, overrides); err != nil {
+		t.Fatal(err)
+	}
+	if config.ClusterName != "test" || config.Domain != "set.example.com" || !config.ACMEEnabled || config.WebhookURL != "https://hooks.slack.com/services/x" ||
+		len(config.Bastion) != 1 || config.Bastion[0].Port != 2222 {
+		t.Errorf("Unexpected config: %+v", config)
+	}
+	if err := applyConfigOverrides(&config, []configOverride{{"ACMEEnabled", "maybe", "--set"}}); err == nil {
+		t.Error("Wrong bool must be reported")
+	}
+
+	config.Email = "ops@example.com"
+	toWrite, err := withoutConfigOverrides(&config, fileConfig, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if toWrite.Domain != "file.example.com" || toWrite.WebhookURL != "" || toWrite.ACMEEnabled || len(toWrite.Bastion) != 0 || toWrite.Email != "ops@example.com" {
+		t.Errorf("Overridden values must not be written: %+v", toWrite)
+	}
+
+	problems := validateClusterConfig([]byte("Organization: test\nCluster: test\nRootUser: root\nClusterUser: cluster\n"),
+		[]configOverride{{"ACMEEnabled", "true", "SWARMGO_ACMEENABLED"}, {"Email", "admin@example.com", "--set"}})
+	if len(problems) != 1 || problems[0].String() != "ACMEEnabled (SWARMGO_ACMEENABLED): Domain is required when ACME is enabled" {
+		t.Error("Unexpected problems:", problems)
+	}
+}
@@ -0,0 +1,242 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	gc "github.com/untillpro/gochips"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v2"
+)
+
+// imageRefRegexp follows docker reference grammar: [domain[:port]/]name[/name...][:tag][@digest]
+var imageRefRegexp = regexp.MustCompile(`^` +
+	`(?:(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*(?::[0-9]+)?/)?` +
+	`[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*` +
+	`(?::[\w][\w.-]{0,127})?` +
+	`(?:@[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,})?$`)
+
+var clusterNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+var domainRegexp = regexp.MustCompile(`^(?:\*\.)?[A-Za-z0-9](?:[A-Za-z0-9-]*[A-Za-z0-9])?(?:\.[A-Za-z0-9](?:[A-Za-z0-9-]*[A-Za-z0-9])?)+$`)
+var emailRegexp = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+// yaml.v2 reports problems as "line N: message"
+var yamlLineRegexp = regexp.MustCompile(`line (\d+): (.*)$`)
+var yamlUnknownFieldRegexp = regexp.MustCompile(`^field (\S+) not found in type \S+$`)
+var topLevelKeyRegexp = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9]*)\s*:`)
+
+// configProblem is a problem found in swarmgo-config.yml, Line is zero if key is missing
+type configProblem struct {
+	Line    int
+	Message string
+}
+
+func (p configProblem) String() string {
+	if p.Line == 0 {
+		return p.Message
+	}
+	return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+}
+
+// topLevelKeyLines returns lines of top level keys, the first occurrence is kept
+func topLevelKeyLines(content []byte) map[string]int {
+	res := make(map[string]int)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		if m := topLevelKeyRegexp.FindStringSubmatch(scanner.Text()); m != nil {
+			if _, ok := res[m[1]]; !ok {
+				res[m[1]] = line
+			}
+		}
+	}
+	return res
+}
+
+// yamlProblems converts yaml.v2 errors to problems, unknown keys are reported by name
+func yamlProblems(err error) []configProblem {
+	messages := []string{err.Error()}
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		messages = typeErr.Errors
+	}
+	res := make([]configProblem, 0, len(messages))
+	for _, m := range messages {
+		p := configProblem{Message: strings.TrimPrefix(m, "yaml: ")}
+		if match := yamlLineRegexp.FindStringSubmatch(m); match != nil {
+			p.Line, _ = strconv.Atoi(match[1])
+			p.Message = match[2]
+		}
+		if match := yamlUnknownFieldRegexp.FindStringSubmatch(p.Message); match != nil {
+			p.Message = "unknown key " + match[1]
+		}
+		res = append(res, p)
+	}
+	return res
+}
+
+// validateClusterConfig checks swarmgo-config.yml content with overrides applied: unknown keys, required values,
+// image references and settings which depend on each other. Problems are sorted by line
+func validateClusterConfig(content []byte, overrides []configOverride) []configProblem {
+	config := clusterFile{}
+	err := yaml.UnmarshalStrict(content, &config)
+	if err != nil {
+		if _, ok := err.(*yaml.TypeError); !ok {
+			return yamlProblems(err) // Syntax error, nothing to check further
+		}
+	}
+	res := make([]configProblem, 0)
+	if err != nil {
+		res = append(res, yamlProblems(err)...)
+	}
+	if err := applyConfigOverrides(&config, overrides); err != nil {
+		res = append(res, configProblem{Message: err.Error()})
+	}
+	lines := topLevelKeyLines(content)
+	origins := make(map[string]string)
+	for _, o := range overrides {
+		origins[o.Key] = o.Origin
+	}
+	report := func(key, format string, args ...interface{}) {
+		if origin, ok := origins[key]; ok {
+			res = append(res, configProblem{0, key + " (" + origin + "): " + fmt.Sprintf(format, args...)})
+			return
+		}
+		res = append(res, configProblem{lines[key], key + ": " + fmt.Sprintf(format, args...)})
+	}
+
+	if config.ConfigVersion > currentConfigVersion {
+		report("ConfigVersion", "version %d is not supported, this swarmgo supports up to %d, upgrade swarmgo", config.ConfigVersion, currentConfigVersion)
+	}
+	for _, r := range []struct{ key, value string }{
+		{"Organization", config.OrganizationName},
+		{"Cluster", config.ClusterName},
+		{"RootUser", config.RootUserName},
+		{"ClusterUser", config.ClusterUserName},
+	} {
+		if len(strings.TrimSpace(r.value)) == 0 {
+			report(r.key, "value is required")
+		}
+	}
+	if len(config.ClusterName) > 0 && !clusterNameRegexp.MatchString(config.ClusterName) {
+		report("Cluster", "wrong cluster name %s, must match %s", config.ClusterName, clusterNameRegexp.String())
+	}
+	for _, u := range []struct{ key, value string }{{"RootUser", config.RootUserName}, {"ClusterUser", config.ClusterUserName}} {
+		if len(u.value) > 0 && !userNameRegexp.MatchString(u.value) {
+			report(u.key, "wrong user name %s, must match %s", u.value, userNameRegexp.String())
+		}
+	}
+	if len(config.ClusterUserName) > 0 && (config.ClusterUserName == config.RootUserName || config.ClusterUserName == "root") {
+		report("ClusterUser", "must differ from RootUser and root")
+	}
+
+	if (len(config.PublicKey) == 0) != (len(config.PrivateKey) == 0) {
+		report("PublicKey", "PublicKey and PrivateKey must be specified together")
+	}
+	if len(config.KeyType) > 0 && !contains(keyTypes, config.KeyType) {
+		report("KeyType", "unknown key type %s, must be one of: %s", config.KeyType, strings.Join(keyTypes, ", "))
+	}
+
+	for _, i := range []struct{ key, value string }{
+		{"Alertmanager", config.Alertmanager},
+		{"NodeExporter", config.NodeExporter},
+		{"Prometheus", config.Prometheus},
+		{"Grafana", config.Grafana},
+		{"Traefik", config.Traefik},
+		{"Socat", config.Socat},
+		{"Cadvisor", config.Cadvisor},
+		{"Consul", config.Consul},
+		{"Elasticsearch", config.Elasticsearch},
+		{"Filebeat", config.Filebeat},
+		{"Kibana", config.Kibana},
+		{"Logstash", config.Logstash},
+		{"Curator", config.Curator},
+	} {
+		if len(i.value) > 0 && !imageRefRegexp.MatchString(i.value) {
+			report(i.key, "wrong image reference %s, expected [registry/]name[:tag][@digest]", i.value)
+		}
+	}
+
+	if config.ACMEEnabled {
+		if len(config.Domain) == 0 {
+			report("ACMEEnabled", "Domain is required when ACME is enabled")
+		}
+		if len(config.Email) == 0 {
+			report("ACMEEnabled", "Email is required when ACME is enabled")
+		}
+	}
+	if len(config.Domain) > 0 && !domainRegexp.MatchString(config.Domain) {
+		report("Domain", "wrong domain name %s", config.Domain)
+	}
+	if len(config.Email) > 0 && !emailRegexp.MatchString(config.Email) {
+		report("Email", "wrong email %s", config.Email)
+	}
+
+	for i, b := range config.Bastion {
+		if len(b.Host) == 0 {
+			report("Bastion", "host #%d: Host is required", i+1)
+		}
+		if b.Port < 0 || b.Port > 65535 {
+			report("Bastion", "host #%d: wrong port %d", i+1, b.Port)
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, u := range config.Users {
+		if err := checkUserName(&config, u.Name); err != nil {
+			report("Users", "%v", err)
+		}
+		if names[u.Name] {
+			report("Users", "user %s is declared twice", u.Name)
+		}
+		names[u.Name] = true
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(u.PublicKey)); err != nil {
+			report("Users", "wrong public key of %s: %v", u.Name, err)
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Line < res[j].Line })
+	return res
+}
+
+// exitIfClusterConfigInvalid reports all problems of swarmgo-config.yml at once
+func exitIfClusterConfigInvalid(content []byte, overrides []configOverride) {
+	problems := validateClusterConfig(content, overrides)
+	if len(problems) == 0 {
+		return
+	}
+	for _, p := range problems {
+		gc.Error(swarmgoConfigFileName + ": " + p.String())
+	}
+	gc.Fatal(fmt.Sprintf("%s is invalid, %d problem(s) found", swarmgoConfigFileName, len(problems)))
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manages swarmgo-config.yml",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Checks swarmgo-config.yml, every command also checks it before start",
+	Long: `Reports unknown keys, missing required values, wrong image references and inconsistent settings,
+e.g. ACMEEnabled without Domain and Email. Values given by environment variables and --set options are checked as well`,
+	Run: func(cmd *cobra.Command, args []string) {
+		content := readWorkingFileIfExists(swarmgoConfigFileName, "You should create swarmgo-config.yml")
+		exitIfClusterConfigInvalid(content, readClusterConfigOverrides())
+		gc.Info(filepath.Join(getWorkingDir(), swarmgoConfigFileName) + " is valid")
+	},
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateClusterConfig(t *testing.T) {
+	template := executeTemplateToFile(swarmgoConfigFileName, clusterFile{OrganizationName: "test", ClusterName: "test"})
+	if problems := validateClusterConfig(template.Bytes(), nil); len(problems) != 0 {
+		t.Error("Config created by init must be valid:", problems)
+	}
+
+	config := `Organization: test
+Cluster: test
+RootUser: root
+ClusterUser: cluster
+Traefk: traefik:v2.1.0
+Traefik: Traefik:latest
+Consul: registry.example.com:5000/library/consul:1.4.2
+ACMEEnabled: true
+Email: admin
+EncryptSwarmNetworks: yes please
+Users:
+  - Name: alice
+    PublicKey: ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIFake alice
+webhookurl: https://hooks.slack.com/services/x
+`
+	expected := []string{
+		"line 5: unknown key Traefk",
+		"line 6: Traefik: wrong image reference Traefik:latest, expected [registry/]name[:tag][@digest]",
+		"line 8: ACMEEnabled: Domain is required when ACME is enabled",
+		"line 9: Email: wrong email admin",
+		"line 10: cannot unmarshal !!str `yes please` into bool",
+		"line 11: Users: wrong public key of alice: ssh: no key found",
+	}
+	actual := make([]string, 0)
+	for _, p := range validateClusterConfig([]byte(config), nil) {
+		actual = append(actual, p.String())
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected problems:\n%s", strings.Join(actual, "\n"))
+	}
+
+	problems := validateClusterConfig([]byte("Organization: test\nCluster: [test\n"), nil)
+	if len(problems) != 1 || problems[0].Line == 0 {
+		t.Error("Syntax error must be reported with line:", problems)
+	}
+	problems = validateClusterConfig([]byte("Organization: test\nRootUser: root\nClusterUser: root\n"), nil)
+	if len(problems) != 2 || problems[0].String() != "Cluster: value is required" || problems[1].String() != "line 3: ClusterUser: must differ from RootUser and root" {
+		t.Error("Unexpected problems:", problems)
+	}
+}
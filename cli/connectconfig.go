@@ -0,0 +1,201 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	gc "github.com/untillpro/gochips"
+)
+
+var connectConfigOutputArg string
+var connectConfigPrefixArg string
+var connectConfigIncludeArg bool
+var connectConfigGrantDockerArg bool
+
+// sshConfigBastionAlias includes user and port, so bastions on the same host don't collide. ProxyJump treats @ and : as
+// user and port separators, so they are not used
+func sshConfigBastionAlias(prefix string, b bastionHost) string {
+	return fmt.Sprintf("%sbastion-%s-%s-%d", prefix, b.User, strings.ReplaceAll(b.Host, ":", "_"), b.Port)
+}
+
+func writeSSHConfigHost(buf *bytes.Buffer, alias, host, user string, port int, identityFile string, jumps []string) {
+	fmt.Fprintf(buf, "Host %s\n", alias)
+	fmt.Fprintf(buf, "  HostName %s\n", host)
+	fmt.Fprintf(buf, "  User %s\n", user)
+	fmt.Fprintf(buf, "  Port %d\n", port)
+	if len(identityFile) > 0 {
+		fmt.Fprintf(buf, "  IdentityFile \"%s\"\n", identityFile)
+		buf.WriteString("  IdentitiesOnly yes\n")
+	}
+	if len(jumps) > 0 {
+		fmt.Fprintf(buf, "  ProxyJump %s\n", strings.Join(jumps, ","))
+	}
+	buf.WriteString("\n")
+}
+
+// sshConfigText returns ssh_config with Host entry per node alias, bastion hosts get own entries used by ProxyJump
+func sshConfigText(clusterFile *clusterFile, nodes []node, clusterPrivateKey, prefix string) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Generated by `swarmgo connect-config` for cluster %s, changes will be overwritten\n\n", clusterFile.ClusterName)
+
+	bastionsWritten := make(map[string]bool)
+	for _, n := range nodes {
+		bastion := clusterFile.Bastion
+		if len(n.Bastion) > 0 {
+			bastion = n.Bastion
+		}
+		jumps := make([]string, 0, len(bastion))
+		for _, b := range withBastionDefaults(bastion, clusterFile.ClusterUserName, clusterPrivateKey) {
+			alias := sshConfigBastionAlias(prefix, b)
+			jumps = append(jumps, alias)
+			if !bastionsWritten[alias] {
+				writeSSHConfigHost(&buf, alias, b.Host, b.User, b.Port, b.PrivateKey, nil)
+				bastionsWritten[alias] = true
+			}
+		}
+
+		user, port, key := clusterFile.ClusterUserName, defaultSSHPort, clusterPrivateKey
+		if len(n.SSHUser) > 0 {
+			user = n.SSHUser
+		}
+		if n.SSHPort > 0 {
+			port = n.SSHPort
+		}
+		if len(n.SSHKey) > 0 {
+			key = n.SSHKey
+		}
+		writeSSHConfigHost(&buf, prefix+n.Alias, n.Host, user, port, key, jumps)
+	}
+	return buf.String()
+}
+
+// includeSSHConfig adds Include of the generated file to the top of ~/.ssh/config, Include is ignored inside Host sections
+func includeSSHConfig(userConfigFile, includedFile string) (bool, error) {
+	content, err := ioutil.ReadFile(userConfigFile)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	include := "Include \"" + includedFile + "\""
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == include {
+			return false, nil
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(userConfigFile), 0700); err != nil {
+		return false, err
+	}
+	return true, ioutil.WriteFile(userConfigFile, append([]byte(include+"\n\n"), content...), 0600)
+}
+
+// createDockerContext creates or updates docker context which points to the leader over ssh
+func createDockerContext(name, sshHost string) error {
+	if _, err := exec.LookPath("docker"); err != nil {
+		return fmt.Errorf("docker CLI not found: %v", err)
+	}
+	action := "create"
+	if exec.Command("docker", "context", "inspect", name).Run() == nil {
+		action = "update"
+	}
+	cmd := exec.Command("docker", "context", action, name,
+		"--description", "swarmgo cluster "+name, "--docker", "host=ssh://"+sshHost)
+	gc.Verbose("Running", cmd.Args)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("docker context %s failed: %v: %s", action, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// checkDockerGroup makes sure docker can be used over ssh without sudo, user is added to docker group if grant is true
+func checkDockerGroup(client Executor, leader node, user string, grant bool) error {
+	groups, err := client.Exec(leader.Host, "id -nG")
+	if err != nil {
+		return err
+	}
+	for _, group := range strings.Fields(groups) {
+		if group == "docker" {
+			return nil
+		}
+	}
+	if !grant {
+		return fmt.Errorf("%s isn't a member of docker group on %s, docker context won't work. Use --grant-docker option to add it", user, leader.Alias)
+	}
+	logWithPrefix(leader.Host, "Adding "+user+" to docker group")
+	_, err = client.Exec(leader.Host, "sudo usermod -aG docker "+user)
+	return err
+}
+
+var connectConfigCmd = &cobra.Command{
+	Use:   "connect-config",
+	Short: "Generates SSH config for cluster nodes and docker context for the leader",
+	Long: `Generates SSH config include file with Host entry per node alias, so nodes are accessed as ` + "`ssh <alias>`" + `.
+Creates docker context named after the cluster which points to the leader node over ssh, use ` + "`docker --context <cluster> stack ls`",
+	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
+		clusterFile := unmarshalClusterYml()
+		nodes := getNodesFromYml(getWorkingDir())
+		gc.ExitIfFalse(len(nodes) > 0, "Can't find nodes from nodes.yml. Add some nodes first!")
+		_, privateKeyFile := findSSHKeys(clusterFile)
+		privateKeyFile, err := filepath.Abs(privateKeyFile)
+		gc.ExitIfError(err)
+
+		home, err := homedir.Dir()
+		gc.ExitIfError(err)
+		output := connectConfigOutputArg
+		if len(output) == 0 {
+			output = filepath.Join(home, ".ssh", "swarmgo-"+clusterFile.ClusterName+".conf")
+		}
+		output, err = filepath.Abs(output)
+		gc.ExitIfError(err)
+		gc.ExitIfError(os.MkdirAll(filepath.Dir(output), 0700))
+		gc.ExitIfError(ioutil.WriteFile(output, []byte(sshConfigText(clusterFile, nodes, privateKeyFile, connectConfigPrefixArg)), 0600))
+		gc.Info("SSH config written to " + output)
+
+		userConfigFile := filepath.Join(home, ".ssh", "config")
+		if connectConfigIncludeArg {
+			added, err := includeSSHConfig(userConfigFile, output)
+			gc.ExitIfError(err)
+			if added {
+				gc.Info("Include added to " + userConfigFile)
+			}
+		} else {
+			gc.Info("Add `Include \"" + output + "\"` to the top of " + userConfigFile + " or use --include option")
+		}
+
+		var leaderNode node
+		for _, n := range nodes {
+			if n.SwarmMode == leader {
+				leaderNode = n
+			}
+		}
+		if len(leaderNode.Host) == 0 {
+			gc.Info("Swarm leader not found, docker context is not created")
+			return
+		}
+		checkSSHAgent()
+		user := clusterFile.ClusterUserName
+		if len(leaderNode.SSHUser) > 0 {
+			user = leaderNode.SSHUser
+		}
+		if err := checkDockerGroup(getSSHClient(clusterFile), leaderNode, user, connectConfigGrantDockerArg); err != nil {
+			gc.Info(err)
+		}
+		gc.ExitIfError(createDockerContext(clusterFile.ClusterName, connectConfigPrefixArg+leaderNode.Alias))
+		gc.Info("Docker context " + clusterFile.ClusterName + " points to " + leaderNode.Alias + " over ssh, SSH config must be included")
+		gc.Info("Run `docker --context " + clusterFile.ClusterName + " stack ls` or `docker context use " + clusterFile.ClusterName + "`")
+	}),
+}
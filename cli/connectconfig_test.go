@@ -0,0 +1,45 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSSHConfigText(t *testing.T) {
+	clusterFile := &clusterFile{
+		ClusterName:     "prod",
+		ClusterUserName: "cluster",
+		Bastion:         []bastionHost{{Host: "bastion.example.com", User: "jump"}},
+	}
+	nodes := []node{
+		{Alias: "node1", Host: "10.0.0.1"},
+		{Alias: "node2", Host: "10.0.0.2", SSHUser: "ubuntu", SSHPort: 2222, SSHKey: "/keys/ubuntu"},
+		{Alias: "node3", Host: "10.0.0.3", Bastion: []bastionHost{{Host: "bastion.example.com", User: "admin", Port: 2200}}},
+	}
+	text := sshConfigText(clusterFile, nodes, "/keys/cluster", "prod-")
+	expected := []string{
+		"Host prod-bastion-jump-bastion.example.com-22\n  HostName bastion.example.com\n  User jump\n  Port 22\n  IdentityFile \"/keys/cluster\"\n  IdentitiesOnly yes\n\n",
+		"Host prod-node1\n  HostName 10.0.0.1\n  User cluster\n  Port 22\n  IdentityFile \"/keys/cluster\"\n  IdentitiesOnly yes\n  ProxyJump prod-bastion-jump-bastion.example.com-22\n\n",
+		"Host prod-node2\n  HostName 10.0.0.2\n  User ubuntu\n  Port 2222\n  IdentityFile \"/keys/ubuntu\"\n  IdentitiesOnly yes\n  ProxyJump prod-bastion-jump-bastion.example.com-22\n\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(text, e) {
+			t.Error("Expected:\n", e, "\nin:\n", text)
+		}
+	}
+	if strings.Count(text, "Host prod-bastion-jump-") != 1 {
+		t.Error("Bastion must be written once:\n", text)
+	}
+	if !strings.Contains(text, "Host prod-bastion-admin-bastion.example.com-2200\n  HostName bastion.example.com\n  User admin\n  Port 2200\n") ||
+		!strings.Contains(text, "ProxyJump prod-bastion-admin-bastion.example.com-2200\n") {
+		t.Error("Bastion with other user and port on the same host must get own entry:\n", text)
+	}
+}
@@ -0,0 +1,188 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	gc "github.com/untillpro/gochips"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	clusterEnvVar    = "SWARMGO_CLUSTER"
+	contextsFileName = "contexts.yml"
+	contextKeysDir   = "keys"
+)
+
+var clusterArg string
+
+// contextsFile is ~/.swarmgo/contexts.yml, every context is a dir with swarmgo-config.yml, nodes.yml, logs and keys
+type contextsFile struct {
+	Current  string            `yaml:"Current,omitempty"`
+	Contexts map[string]string `yaml:"Contexts,omitempty"` // Dir by context name
+}
+
+// selectedContext returns name of the context to use and where it is selected: --cluster flag, SWARMGO_CLUSTER or `context use`.
+// Empty name means no context is selected and working dir is found in the current dir
+func selectedContext(contexts *contextsFile, flag, env string) (string, string, error) {
+	name, source := contexts.Current, "current context"
+	if len(env) > 0 {
+		name, source = env, clusterEnvVar
+	}
+	if len(flag) > 0 {
+		name, source = flag, "--cluster"
+	}
+	if len(name) == 0 {
+		return "", "", nil
+	}
+	if _, ok := contexts.Contexts[name]; !ok {
+		return "", "", fmt.Errorf("unknown cluster context %s given by %s, run `swarmgo context ls` to list contexts", name, source)
+	}
+	return name, source, nil
+}
+
+func swarmgoHomeDir() string {
+	home, err := homedir.Dir()
+	gc.ExitIfError(err)
+	return filepath.Join(home, ".swarmgo")
+}
+
+func readContexts() *contextsFile {
+	res := &contextsFile{}
+	content, err := ioutil.ReadFile(filepath.Join(swarmgoHomeDir(), contextsFileName))
+	if os.IsNotExist(err) {
+		return res
+	}
+	gc.ExitIfError(err)
+	gc.ExitIfError(yaml.Unmarshal(content, res), "Unable to read "+contextsFileName)
+	return res
+}
+
+func writeContexts(contexts *contextsFile) {
+	content, err := yaml.Marshal(contexts)
+	gc.ExitIfError(err)
+	gc.ExitIfError(os.MkdirAll(swarmgoHomeDir(), 0700))
+	gc.ExitIfError(ioutil.WriteFile(filepath.Join(swarmgoHomeDir(), contextsFileName), content, 0600))
+}
+
+// clusterContext is resolved once per command
+var clusterContext struct {
+	sync.Once
+	name, dir string
+}
+
+// contextDir returns dir of the selected context, false if no context is selected
+func contextDir() (string, bool) {
+	clusterContext.Do(func() {
+		contexts := readContexts()
+		name, _, err := selectedContext(contexts, clusterArg, os.Getenv(clusterEnvVar))
+		gc.ExitIfError(err)
+		if len(name) > 0 {
+			clusterContext.name, clusterContext.dir = name, contexts.Contexts[name]
+			gc.ExitIfError(os.MkdirAll(clusterContext.dir, 0700))
+		}
+	})
+	return clusterContext.dir, len(clusterContext.dir) > 0
+}
+
+// contextSSHKeys returns default key files of the context, keys from ~/.ssh are kept in use if context has no own keys yet
+func contextSSHKeys(dir, clusterName, legacyPublicKey, legacyPrivateKey string) (string, string) {
+	publicKeyFile := filepath.Join(dir, contextKeysDir, clusterName+".pub")
+	privateKeyFile := filepath.Join(dir, contextKeysDir, clusterName)
+	if !FileExists(privateKeyFile) && FileExists(legacyPrivateKey) {
+		return legacyPublicKey, legacyPrivateKey
+	}
+	return publicKeyFile, privateKeyFile
+}
+
+var contextCmd = &cobra.Command{
+	Use:   "context",
+	Short: "Manages cluster contexts",
+	Long: `Context is a named dir with swarmgo-config.yml, nodes.yml, logs and keys of one cluster.
+Context is selected by --cluster option, ` + clusterEnvVar + ` environment variable or ` + "`swarmgo context use`" + `.
+Without context nodes.yml, .nodes or nodes folder in the current dir is used`,
+}
+
+var contextLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "Lists cluster contexts, selected one is marked by *",
+	Run: func(cmd *cobra.Command, args []string) {
+		contexts := readContexts()
+		selected, source, err := selectedContext(contexts, clusterArg, os.Getenv(clusterEnvVar))
+		gc.ExitIfError(err)
+		names := make([]string, 0, len(contexts.Contexts))
+		for name := range contexts.Contexts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "\tNAME\tDIR")
+		for _, name := range names {
+			mark := ""
+			if name == selected {
+				mark = "*"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", mark, name, contexts.Contexts[name])
+		}
+		w.Flush()
+		if len(selected) > 0 {
+			fmt.Printf("\n%s is selected by %s\n", selected, source)
+		}
+	},
+}
+
+var contextUseCmd = &cobra.Command{
+	Use:   "use <name>",
+	Short: "Makes context current, further commands use its dir",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		contexts := readContexts()
+		_, ok := contexts.Contexts[args[0]]
+		gc.ExitIfFalse(ok, "Unknown context "+args[0]+", run `swarmgo context create` first")
+		contexts.Current = args[0]
+		writeContexts(contexts)
+		gc.Info("Current context is " + args[0] + ": " + contexts.Contexts[args[0]])
+	},
+}
+
+var contextCreateCmd = &cobra.Command{
+	Use:   "create <name> [dir]",
+	Short: "Creates context, dir is ~/.swarmgo/clusters/<name> by default, existing dir can be given",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		gc.ExitIfFalse(clusterNameRegexp.MatchString(name), fmt.Sprintf("Wrong context name %s, must match %s", name, clusterNameRegexp.String()))
+		contexts := readContexts()
+		_, exists := contexts.Contexts[name]
+		gc.ExitIfFalse(!exists, "Context "+name+" already exists")
+		dir := filepath.Join(swarmgoHomeDir(), "clusters", name)
+		if len(args) > 1 {
+			var err error
+			dir, err = filepath.Abs(args[1])
+			gc.ExitIfError(err)
+		}
+		gc.ExitIfError(os.MkdirAll(dir, 0700))
+		if contexts.Contexts == nil {
+			contexts.Contexts = make(map[string]string)
+		}
+		contexts.Contexts[name] = dir
+		writeContexts(contexts)
+		gc.Info("Context " + name + " created: " + dir)
+		gc.Info("Run `swarmgo context use " + name + "` to make it current, then `swarmgo init`")
+	},
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSelectedContext(t *testing.T) {
+	contexts := &contextsFile{Current: "prod", Contexts: map[string]string{"prod": "/prod", "stage": "/stage", "dev": "/dev"}}
+	for _, c := range []struct{ flag, env, name, source string }{
+		{"", "", "prod", "current context"},
+		{"", "stage", "stage", clusterEnvVar},
+		{"dev", "stage", "dev", "--cluster"},
+	} {
+		name, source, err := selectedContext(contexts, c.flag, c.env)
+		if err != nil || name != c.name || source != c.source {
+			t.Error("Unexpected context:", c, name, source, err)
+		}
+	}
+	if _, _, err := selectedContext(contexts, "qa", ""); err == nil {
+		t.Error("Unknown context must be reported")
+	}
+	if name, _, err := selectedContext(&contextsFile{}, "", ""); err != nil || name != "" {
+		t.Error("No context must be selected:", name, err)
+	}
+}
+
+func TestContextSSHKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarmgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	legacyPublic, legacyPrivate := filepath.Join(dir, ".ssh", "prod.pub"), filepath.Join(dir, ".ssh", "prod")
+	contextDir := filepath.Join(dir, "prod")
+
+	if _, private := contextSSHKeys(contextDir, "prod", legacyPublic, legacyPrivate); private != filepath.Join(contextDir, "keys", "prod") {
+		t.Error("New keys must be kept in context:", private)
+	}
+	os.MkdirAll(filepath.Dir(legacyPrivate), 0700)
+	ioutil.WriteFile(legacyPrivate, []byte("key"), 0600)
+	if _, private := contextSSHKeys(contextDir, "prod", legacyPublic, legacyPrivate); private != legacyPrivate {
+		t.Error("Existing keys from ~/.ssh must be used:", private)
+	}
+}
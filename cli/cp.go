@@ -0,0 +1,315 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/spf13/cobra"
+	gc "github.com/untillpro/gochips"
+)
+
+var cpModeArg string
+var cpOwnerArg string
+
+// parseRemotePath splits <selector>:<path>. Argument is remote only if the part before a colon is a selector which
+// matches nodes, so local paths with colons like backup:2024 stay local. Paths starting with ./, ../ or / and
+// Windows paths like C:\dir are always local
+func parseRemotePath(arg string, nodes []node) (selector, remotePath string, ok bool) {
+	if len(filepath.VolumeName(arg)) > 0 || filepath.IsAbs(arg) || strings.HasPrefix(arg, "/") {
+		return "", "", false
+	}
+	for _, prefix := range []string{"./", "../", "." + string(filepath.Separator), ".." + string(filepath.Separator)} {
+		if strings.HasPrefix(arg, prefix) {
+			return "", "", false
+		}
+	}
+	// Selector may contain colons itself, e.g. label:zone=a
+	for i := 1; i < len(arg); i++ {
+		if arg[i] == ':' && isNodeSelector(nodes, arg[:i]) {
+			return arg[:i], arg[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// isNodeSelector returns true if selector matches nodes, selectors which need live swarm data are not resolved
+func isNodeSelector(nodes []node, selector string) bool {
+	live := false
+	_, err := selectNodes(nodes, selector, func() (map[string]swarmNode, error) {
+		live = true
+		return nil, errors.New("not resolved")
+	})
+	return err == nil || live
+}
+
+// localEntry is a file or directory to be copied, path is relative to the copied root and uses forward slashes
+type localEntry struct {
+	path string
+	file string
+	info os.FileInfo
+}
+
+// walkLocal lists the root and everything under it, directories go before their content
+func walkLocal(root string) ([]localEntry, error) {
+	base := filepath.Base(root)
+	entries := make([]localEntry, 0)
+	err := filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			gc.Info("Skipping " + file + ", only regular files are copied")
+			return nil
+		}
+		entries = append(entries, localEntry{path.Join(base, filepath.ToSlash(rel)), file, info})
+		return nil
+	})
+	return entries, err
+}
+
+// targetPath maps path of the copied entry to the destination, root of the copy is renamed to target
+func targetPath(target, entryPath string) string {
+	if i := strings.Index(entryPath, "/"); i >= 0 {
+		return path.Join(target, entryPath[i+1:])
+	}
+	return target
+}
+
+// PushOptions are options of Push
+type PushOptions struct {
+	Mode    os.FileMode // Mode of copied files if ModeSet is true, modes of local files are kept otherwise
+	ModeSet bool
+	Owner   string // Owner of copied files as user[:group], changed by sudo if not empty
+}
+
+// pushToNode copies local entries to the node, target is the path of the copied root on the node.
+// Modes are set by chmod after copying, since scp keeps mode of existing files and applies umask to new ones
+func pushToNode(client Executor, n node, entries []localEntry, target string, options PushOptions) error {
+	dirs := make([]string, 0)
+	for _, e := range entries {
+		if e.info.IsDir() {
+			dirs = append(dirs, fmt.Sprintf("mkdir -p %[1]s && chmod %#[2]o %[1]s", shellquote.Join(targetPath(target, e.path)), e.info.Mode().Perm()))
+		}
+	}
+	if len(dirs) > 0 {
+		if _, err := client.Exec(n.Host, strings.Join(dirs, " && ")); err != nil {
+			return err
+		}
+	}
+	filesByMode := make(map[os.FileMode][]string)
+	modes := make([]os.FileMode, 0)
+	for _, e := range entries {
+		if e.info.IsDir() {
+			continue
+		}
+		mode := e.info.Mode().Perm()
+		if options.ModeSet {
+			mode = options.Mode
+		}
+		f, err := os.Open(e.file)
+		if err != nil {
+			return err
+		}
+		err = client.Copy(n.Host, e.info.Size(), mode, path.Base(e.path), f, targetPath(target, e.path))
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("unable to copy %s: %v", e.file, err)
+		}
+		if _, ok := filesByMode[mode]; !ok {
+			modes = append(modes, mode)
+		}
+		filesByMode[mode] = append(filesByMode[mode], targetPath(target, e.path))
+	}
+	chmods := make([]string, 0, len(modes))
+	for _, mode := range modes {
+		chmods = append(chmods, fmt.Sprintf("chmod %#o %s", mode, shellquote.Join(filesByMode[mode]...)))
+	}
+	if len(chmods) > 0 {
+		if _, err := client.Exec(n.Host, strings.Join(chmods, " && ")); err != nil {
+			return fmt.Errorf("unable to set mode: %v", err)
+		}
+	}
+	if len(options.Owner) > 0 {
+		if _, err := client.Exec(n.Host, "sudo chown -R "+shellquote.Join(options.Owner, target)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Push copies local file or directory to the remote path on given nodes in parallel.
+// If remote path ends with / or is a directory, copy is placed into it
+func Push(client Executor, nodes []node, local, remotePath string, options PushOptions) error {
+	local = filepath.Clean(local)
+	entries, err := walkLocal(local)
+	if err != nil {
+		return err
+	}
+	errs := make(chan error)
+	for _, n := range nodes {
+		go func(n node) {
+			target := path.Clean(remotePath)
+			isDir, err := client.Run(n.Host, "test -d "+shellquote.Join(target))
+			if err == nil && (strings.HasSuffix(remotePath, "/") || isDir.ExitCode == 0) {
+				target = path.Join(target, filepath.Base(local))
+			}
+			if err == nil {
+				logWithPrefix(n.Alias, fmt.Sprintf("Copying %s to %s", local, target))
+				err = pushToNode(client, n, entries, target, options)
+			}
+			if err != nil {
+				err = fmt.Errorf("%s: %v", n.Alias, err)
+			}
+			errs <- err
+		}(n)
+	}
+	return collectErrors(errs, len(nodes))
+}
+
+// extractTar writes entries of the archive created by `tar -C dir -cf - base` to target, base is renamed to target
+func extractTar(r io.Reader, base, target string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+		if name != base && !strings.HasPrefix(name, base+"/") {
+			return fmt.Errorf("unexpected entry in archive: %s", header.Name)
+		}
+		file := filepath.Join(target, filepath.FromSlash(strings.TrimPrefix(name, base)))
+		mode := os.FileMode(header.Mode).Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(file, mode|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			gc.Info("Skipping " + header.Name + ", only regular files are copied")
+		}
+	}
+}
+
+// Pull copies remote file or directory from given nodes. If several nodes are given, copy from each node is placed to local/<alias>
+func Pull(client Executor, nodes []node, remotePath, local string) error {
+	remotePath = path.Clean(remotePath)
+	base := path.Base(remotePath)
+	command := fmt.Sprintf("tar -C %s -cf - %s", shellquote.Join(path.Dir(remotePath)), shellquote.Join(base))
+	errs := make(chan error)
+	for _, n := range nodes {
+		go func(n node) {
+			target := local
+			if len(nodes) > 1 {
+				target = filepath.Join(local, n.Alias)
+			}
+			if info, err := os.Stat(target); len(nodes) > 1 || err == nil && info.IsDir() {
+				target = filepath.Join(target, base)
+			}
+			logWithPrefix(n.Alias, fmt.Sprintf("Copying %s to %s", remotePath, target))
+			pr, pw := io.Pipe()
+			go func() {
+				pw.CloseWithError(client.Fetch(n.Host, command, pw))
+			}()
+			err := extractTar(pr, base, target)
+			if err == nil {
+				// Remote error is reported after the end of archive
+				_, err = io.Copy(ioutil.Discard, pr)
+			}
+			pr.CloseWithError(errors.New("extraction stopped"))
+			if err != nil {
+				err = fmt.Errorf("%s: %v", n.Alias, err)
+			}
+			errs <- err
+		}(n)
+	}
+	return collectErrors(errs, len(nodes))
+}
+
+// collectErrors waits for count results and reports failed ones
+func collectErrors(errs chan error, count int) error {
+	failed := 0
+	for i := 0; i < count; i++ {
+		if err := <-errs; err != nil {
+			gc.Error(err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("copy failed on %d of %d node(s)", failed, count)
+	}
+	return nil
+}
+
+var cpCmd = &cobra.Command{
+	Use:   "cp <local> <selector>:<remote> | <selector>:<remote> <local>",
+	Short: "Copies files and directories to or from selected nodes",
+	Long: `Copies files and directories recursively to or from selected nodes. Permissions are preserved unless --mode is given.
+When copying from several nodes, copy from each node is placed to <local>/<alias>.
+Argument is remote if the part before a colon is a selector which matches nodes from nodes.yml, so local backup:2024 stays local
+unless node named backup exists. Use ./backup:2024 for such local paths, paths starting with ./, ../ or / are always local.
+` + selectorHelp,
+	Args: cobra.ExactArgs(2),
+	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
+		clusterFile := unmarshalClusterYml()
+		nodesFromYml := getNodesFromYml(getWorkingDir())
+		srcSelector, srcPath, srcRemote := parseRemotePath(args[0], nodesFromYml)
+		dstSelector, dstPath, dstRemote := parseRemotePath(args[1], nodesFromYml)
+		gc.ExitIfFalse(srcRemote != dstRemote, "Exactly one of source and destination must be remote, e.g. node1:/tmp/file, selector before the colon must match nodes from "+nodesFileName)
+		options := PushOptions{Owner: cpOwnerArg}
+		if len(cpModeArg) > 0 {
+			mode, err := strconv.ParseUint(cpModeArg, 8, 32)
+			gc.ExitIfFalse(err == nil && mode <= 0777, "Mode must be octal permissions, e.g. 0644")
+			options.Mode, options.ModeSet = os.FileMode(mode), true
+		}
+
+		checkSSHAgent()
+		client := getSSHClient(clusterFile)
+		if dstRemote {
+			nodes := getNodesBySelector(client, dstSelector)
+			gc.ExitIfError(Push(client, nodes, args[0], dstPath, options))
+		} else {
+			gc.ExitIfFalse(!options.ModeSet && len(options.Owner) == 0, "--mode and --owner options are used when copying to nodes only")
+			nodes := getNodesBySelector(client, srcSelector)
+			gc.ExitIfError(Pull(client, nodes, srcPath, args[1]))
+		}
+		gc.Info("Copied")
+	}),
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseRemotePath(t *testing.T) {
+	nodes := []node{{Alias: "node1", Host: "10.0.0.1", SwarmMode: leader}, {Alias: "node2", Host: "10.0.0.2"}}
+	for arg, expected := range map[string][]string{
+		"node1:/tmp/a":             {"node1", "/tmp/a"},
+		"node1:/tmp/a:b":           {"node1", "/tmp/a:b"},
+		"node[12]:conf/":           {"node[12]", "conf/"},
+		"role=worker:conf/":        {"role=worker", "conf/"},
+		"mode=leader:conf/":        {"mode=leader", "conf/"},
+		"label:zone=a,node1:/x":    {"label:zone=a,node1", "/x"},
+		"./conf":                   nil,
+		":/tmp":                    nil,
+		"backup:2024":              nil,
+		"./node1:2024":             nil,
+		"/var/backup/node1:2024":   nil,
+		"mode=worker:conf/":        nil,
+		filepath.Join("..", "x:y"): nil,
+	} {
+		selector, remotePath, ok := parseRemotePath(arg, nodes)
+		if ok != (expected != nil) || ok && (selector != expected[0] || remotePath != expected[1]) {
+			t.Error(arg, "unexpected", selector, remotePath, ok)
+		}
+	}
+}
+
+func TestPushAndPull(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarmgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "conf")
+	os.MkdirAll(filepath.Join(src, "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(src, "a.yml"), []byte("a"), 0644)
+	ioutil.WriteFile(filepath.Join(src, "sub", "run.sh"), []byte("b"), 0755)
+
+	nodes := []node{{Alias: "node1", Host: "10.0.0.1"}, {Alias: "node2", Host: "10.0.0.2"}}
+	client := newFakeExecutor(
+		fixtureEntry{Host: "10.0.0.1", Command: "test -d /etc/app", ExitCode: 1},
+		fixtureEntry{Host: "10.0.0.2", Command: "test -d /etc/app"},
+		fixtureEntry{Pattern: "^mkdir -p "},
+		fixtureEntry{Pattern: "^chmod "},
+		fixtureEntry{Pattern: "^sudo chown -R root:root "},
+	)
+	if err := Push(client, nodes, src, "/etc/app", PushOptions{Owner: "root:root"}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(client.Copied)
+	expected := []string{"10.0.0.1:/etc/app/a.yml", "10.0.0.1:/etc/app/sub/run.sh", "10.0.0.2:/etc/app/conf/a.yml", "10.0.0.2:/etc/app/conf/sub/run.sh"}
+	if !reflect.DeepEqual(client.Copied, expected) {
+		t.Error("Unexpected copied files:", client.Copied)
+	}
+	if !executed(client, "10.0.0.1", "chmod 0644 /etc/app/a.yml && chmod 0755 /etc/app/sub/run.sh") {
+		t.Error("Modes of local files must be set explicitly:", client.Executed)
+	}
+
+	// Zero mode is a mode as well
+	client = newFakeExecutor(fixtureEntry{Command: "test -d /etc/app", ExitCode: 1}, fixtureEntry{Pattern: "^mkdir -p "}, fixtureEntry{Pattern: "^chmod "})
+	if err := Push(client, nodes[:1], src, "/etc/app", PushOptions{ModeSet: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !executed(client, "10.0.0.1", "chmod 0 /etc/app/a.yml /etc/app/sub/run.sh") {
+		t.Error("Given mode must be set:", client.Executed)
+	}
+	client = newFakeExecutor(fixtureEntry{Command: "test -d /etc/app", ExitCode: 1}, fixtureEntry{Pattern: "^mkdir -p "}, fixtureEntry{Pattern: "^chmod ", ExitCode: 1})
+	if err := Push(client, nodes[:1], src, "/etc/app", PushOptions{}); err == nil {
+		t.Error("Failed chmod must fail the copy")
+	}
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	tw.WriteHeader(&tar.Header{Name: "log/", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: "log/syslog", Typeflag: tar.TypeReg, Mode: 0640, Size: 3})
+	tw.Write([]byte("log"))
+	tw.Close()
+	client = newFakeExecutor(fixtureEntry{Command: "tar -C /var -cf - log", Stdout: archive.String()})
+	pulled := filepath.Join(dir, "pulled")
+	if err := Pull(client, nodes, "/var/log/", pulled); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range nodes {
+		content, err := ioutil.ReadFile(filepath.Join(pulled, n.Alias, "log", "syslog"))
+		if err != nil || string(content) != "log" {
+			t.Error(n.Alias, "unexpected pulled content:", string(content), err)
+		}
+	}
+
+	archive.Reset()
+	tw = tar.NewWriter(&archive)
+	tw.WriteHeader(&tar.Header{Name: "log/../../evil", Typeflag: tar.TypeReg, Mode: 0644})
+	tw.Close()
+	if err := extractTar(&archive, "log", filepath.Join(dir, "evil")); err == nil {
+		t.Error("Entries outside of copied path must be rejected")
+	}
+}
+
+// executed reports whether command was executed on the host
+func executed(client *FakeExecutor, host, command string) bool {
+	for _, e := range client.Executed {
+		if e.Host == host && e.Command == command {
+			return true
+		}
+	}
+	return false
+}
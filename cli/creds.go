@@ -0,0 +1,357 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	gc "github.com/untillpro/gochips"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	credsFileName      = "swarmgo-creds.yml"
+	credsFilePerms     = 0600
+	credsPassphraseEnv = "SWARMGO_CREDS_PASSPHRASE"
+
+	credTraefikPassword      = "traefik-password"
+	credGrafanaPassword      = "grafana-password"
+	credPrometheusPassword   = "prometheus-password"
+	credAlertmanagerPassword = "alertmanager-password"
+	credKibanaUser           = "kibana-user"
+	credKibanaPassword       = "kibana-password"
+	credSlackWebhookURL      = "slack-webhook-url"
+
+	// Letters and digits only, generated passwords are passed to htpasswd in double quotes
+	generatedPasswordChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+// knownCreds are credentials read by deploy commands
+var knownCreds = map[string]string{
+	credTraefikPassword:      "Traefik dashboard password (traefik)",
+	credGrafanaPassword:      "Grafana web-ui password (mon)",
+	credPrometheusPassword:   "Prometheus web-ui password (mon)",
+	credAlertmanagerPassword: "Alertmanager web-ui password (mon)",
+	credKibanaUser:           "Kibana login (elk)",
+	credKibanaPassword:       "Kibana password (elk)",
+	credSlackWebhookURL:      "Slack webhook URL for alerts (mon)",
+}
+
+var credNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+var credsGenerateLengthArg int
+
+// credsFile is encrypted content of swarmgo-creds.yml, values are encrypted by AES-GCM with scrypt derived key
+type credsFile struct {
+	Version int    `yaml:"Version"`
+	N       int    `yaml:"N"` // scrypt parameters
+	R       int    `yaml:"R"`
+	P       int    `yaml:"P"`
+	Salt    []byte `yaml:"Salt"`
+	Nonce   []byte `yaml:"Nonce"`
+	Data    []byte `yaml:"Data"`
+}
+
+func credsCipher(passphrase []byte, f *credsFile) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, f.Salt, f.N, f.R, f.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptCreds returns content of creds file, new salt and nonce are used every time
+func encryptCreds(values map[string]string, passphrase []byte) ([]byte, error) {
+	f := credsFile{Version: 1, N: 1 << 15, R: 8, P: 1, Salt: make([]byte, 16)}
+	if _, err := rand.Read(f.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := credsCipher(passphrase, &f)
+	if err != nil {
+		return nil, err
+	}
+	f.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(f.Nonce); err != nil {
+		return nil, err
+	}
+	plain, err := yaml.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	f.Data = aead.Seal(nil, f.Nonce, plain, nil)
+	return yaml.Marshal(&f)
+}
+
+func decryptCreds(content []byte, passphrase []byte) (map[string]string, error) {
+	f := credsFile{}
+	if err := yaml.Unmarshal(content, &f); err != nil {
+		return nil, err
+	}
+	if f.Version != 1 {
+		return nil, fmt.Errorf("unsupported version %d", f.Version)
+	}
+	aead, err := credsCipher(passphrase, &f)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Nonce) != aead.NonceSize() {
+		return nil, errors.New("wrong nonce")
+	}
+	plain, err := aead.Open(nil, f.Nonce, f.Data, nil)
+	if err != nil {
+		return nil, errors.New("wrong passphrase or damaged file")
+	}
+	values := make(map[string]string)
+	return values, yaml.Unmarshal(plain, &values)
+}
+
+// generatePassword returns random password of given length
+func generatePassword(length int) (string, error) {
+	res := make([]byte, length)
+	max := big.NewInt(int64(len(generatedPasswordChars)))
+	for i := range res {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		res[i] = generatedPasswordChars[n.Int64()]
+	}
+	return string(res), nil
+}
+
+// creds keeps decrypted store during the command, passphrase is asked once per command
+var creds = struct {
+	sync.Mutex
+	passphrase []byte
+	values     map[string]string
+}{}
+
+func credsFilePath() string {
+	return filepath.Join(getWorkingDir(), credsFileName)
+}
+
+func credsExist() bool {
+	_, err := os.Stat(credsFilePath())
+	return err == nil
+}
+
+// credsPassphrase is taken from SWARMGO_CREDS_PASSPHRASE or asked, new store asks for confirmation
+func credsPassphrase(confirm bool) []byte {
+	if value := os.Getenv(credsPassphraseEnv); len(value) > 0 {
+		return []byte(value)
+	}
+	passphrase := readPasswordPrompt("Passphrase of " + credsFileName)
+	gc.ExitIfFalse(len(passphrase) > 0, "Passphrase must not be empty")
+	if confirm {
+		gc.ExitIfFalse(readPasswordPrompt("Repeat passphrase") == passphrase, "Passphrases don't match")
+	}
+	return []byte(passphrase)
+}
+
+// loadCreds decrypts the store, empty store is returned if file doesn't exist and create is true.
+// Must be called with creds locked
+func loadCreds(create bool) {
+	if creds.values != nil {
+		return
+	}
+	content, err := ioutil.ReadFile(credsFilePath())
+	if os.IsNotExist(err) {
+		gc.ExitIfFalse(create, credsFileName+" not found, add credentials by `swarmgo creds set` or `swarmgo creds generate`")
+		gc.Info("Creating " + credsFilePath())
+		creds.passphrase = credsPassphrase(true)
+		creds.values = make(map[string]string)
+		return
+	}
+	gc.ExitIfError(err)
+	passphrase := credsPassphrase(false)
+	values, err := decryptCreds(content, passphrase)
+	gc.ExitIfError(err, "Unable to decrypt "+credsFileName)
+	creds.passphrase, creds.values = passphrase, values
+}
+
+func saveCreds() {
+	content, err := encryptCreds(creds.values, creds.passphrase)
+	gc.ExitIfError(err)
+	tmp := credsFilePath() + ".tmp"
+	gc.ExitIfError(ioutil.WriteFile(tmp, content, credsFilePerms))
+	gc.ExitIfError(os.Rename(tmp, credsFilePath()))
+}
+
+func setCredential(name, value string) {
+	creds.Lock()
+	defer creds.Unlock()
+	loadCreds(true)
+	creds.values[name] = value
+	saveCreds()
+}
+
+// getCredential returns credential from the store. If store doesn't exist value is asked every time,
+// otherwise missing value is asked once and kept in the store
+func getCredential(name string, ask func() string) string {
+	if !credsExist() {
+		return ask()
+	}
+	creds.Lock()
+	defer creds.Unlock()
+	loadCreds(false)
+	if value, ok := creds.values[name]; ok {
+		gc.Verbose("Using " + name + " from " + credsFileName)
+		return value
+	}
+	value := ask()
+	creds.values[name] = value
+	saveCreds()
+	gc.Info(name + " saved to " + credsFileName)
+	return value
+}
+
+// lookupCredential returns credential from the store, false if the store or the credential doesn't exist
+func lookupCredential(name string) (string, bool) {
+	if !credsExist() {
+		return "", false
+	}
+	creds.Lock()
+	defer creds.Unlock()
+	loadCreds(false)
+	value, ok := creds.values[name]
+	return value, ok
+}
+
+func checkCredName(name string) {
+	gc.ExitIfFalse(credNameRegexp.MatchString(name), fmt.Sprintf("Wrong credential name %s, must match %s", name, credNameRegexp.String()))
+}
+
+var credsCmd = &cobra.Command{
+	Use:   "creds",
+	Short: "Manages credentials used by deploy commands",
+	Long: `Credentials are kept in ` + credsFileName + ` next to swarmgo-config.yml, encrypted by passphrase.
+Passphrase is asked once per command or taken from ` + credsPassphraseEnv + ` environment variable.
+traefik, mon and elk commands read passwords and Slack webhook URL from the store if it exists`,
+}
+
+// readCredValue reads value from r, trailing line break is removed
+func readCredValue(r io.Reader) (string, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(b), "\n"), "\r"), nil
+}
+
+var credsSetCmd = &cobra.Command{
+	Use:   "set <name>",
+	Short: "Sets credential, value is asked or read from stdin",
+	Long: `Value is asked if stdin is a terminal, otherwise it is read from stdin, e.g. ` + "`pass show grafana | swarmgo creds set grafana-password`" + `.
+Value is never taken from the command line, so it doesn't leak to shell history and process list.
+When stdin is not a terminal passphrase must be given by ` + credsPassphraseEnv + ` environment variable`,
+	Args: cobra.ExactArgs(1),
+	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
+		checkCredName(args[0])
+		var value string
+		if terminal.IsTerminal(int(syscall.Stdin)) {
+			value = readPasswordPrompt("Value of " + args[0])
+		} else {
+			var err error
+			value, err = readCredValue(os.Stdin)
+			gc.ExitIfError(err)
+		}
+		gc.ExitIfFalse(len(value) > 0, "Value must not be empty")
+		setCredential(args[0], value)
+		gc.Info(args[0] + " saved")
+	}),
+}
+
+var credsGetCmd = &cobra.Command{
+	Use:   "get <name>",
+	Short: "Prints credential value",
+	Args:  cobra.ExactArgs(1),
+	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
+		creds.Lock()
+		defer creds.Unlock()
+		loadCreds(false)
+		value, ok := creds.values[args[0]]
+		gc.ExitIfFalse(ok, args[0]+" not found in "+credsFileName)
+		// Value goes to stdout only, the log gets messages of gochips
+		fmt.Println(value)
+	}),
+}
+
+var credsGenerateCmd = &cobra.Command{
+	Use:   "generate <name>...",
+	Short: "Generates random passwords, use `creds get` to show them",
+	Args:  cobra.MinimumNArgs(1),
+	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
+		gc.ExitIfFalse(credsGenerateLengthArg >= 8, "Password length must be at least 8")
+		for _, name := range args {
+			checkCredName(name)
+		}
+		for _, name := range args {
+			password, err := generatePassword(credsGenerateLengthArg)
+			gc.ExitIfError(err)
+			setCredential(name, password)
+			gc.Info(name + " generated")
+		}
+	}),
+}
+
+var credsLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "Lists stored and known credentials, values are not shown",
+	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
+		stored := make(map[string]string)
+		if credsExist() {
+			creds.Lock()
+			loadCreds(false)
+			stored = creds.values
+			creds.Unlock()
+		}
+		names := make([]string, 0, len(stored)+len(knownCreds))
+		for name := range knownCreds {
+			names = append(names, name)
+		}
+		for name := range stored {
+			if _, ok := knownCreds[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSTORED\tUSED FOR")
+		for _, name := range names {
+			isStored := "no"
+			if _, ok := stored[name]; ok {
+				isStored = "yes"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", name, isStored, knownCreds[name])
+		}
+		w.Flush()
+	}),
+}
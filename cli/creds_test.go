@@ -0,0 +1,43 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCredsEncryption(t *testing.T) {
+	values := map[string]string{credGrafanaPassword: "secret", credSlackWebhookURL: "https://hooks.slack.com/services/x"}
+	content, err := encryptCreds(values, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "secret") || strings.Contains(string(content), "hooks.slack.com") {
+		t.Error("Values must be encrypted:", string(content))
+	}
+	decrypted, err := decryptCreds(content, []byte("passphrase"))
+	if err != nil || len(decrypted) != 2 || decrypted[credGrafanaPassword] != "secret" {
+		t.Error("Unexpected decrypted values:", decrypted, err)
+	}
+	if _, err := decryptCreds(content, []byte("wrong")); err == nil {
+		t.Error("Wrong passphrase must be reported")
+	}
+
+	for input, expected := range map[string]string{"secret\n": "secret", "secret\r\n": "secret", " two lines\nsecret": " two lines\nsecret"} {
+		if value, err := readCredValue(strings.NewReader(input)); err != nil || value != expected {
+			t.Errorf("Unexpected value read from %q: %q %v", input, value, err)
+		}
+	}
+
+	password, err := generatePassword(24)
+	if err != nil || len(password) != 24 || strings.Trim(password, generatedPasswordChars) != "" {
+		t.Error("Unexpected generated password:", password, err)
+	}
+}
@@ -11,12 +11,9 @@ package cli
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"path/filepath"
 
 	"github.com/spf13/cobra"
 	gc "github.com/untillpro/gochips"
-	"gopkg.in/yaml.v2"
 )
 
 const docker = "docker-ce"
@@ -39,15 +36,9 @@ func InstallDocker(upgrade bool, args []string) {
 	for _, node := range nodesFromYaml {
 		aliasesAndNodes[node.Alias] = node
 	}
-	nodesForDocker := make([]node, 0, len(nodesFromYaml))
+	nodesForDocker := nodesFromYaml
 	if len(args) != 0 {
-		for _, arg := range args {
-			val, ok := aliasesAndNodes[arg]
-			gc.ExitIfFalse(ok, "missing in nodes.yml")
-			nodesForDocker = append(nodesForDocker, val)
-		}
-	} else {
-		nodesForDocker = nodesFromYaml
+		nodesForDocker = getNodesBySelector(getSSHClient(clusterFile), args...)
 	}
 	var channelForNodes = make(chan nodeAndError)
 	for _, currentNode := range nodesForDocker {
@@ -79,28 +70,29 @@ func InstallDocker(upgrade bool, args []string) {
 	for _, val := range aliasesAndNodes {
 		nodes = append(nodes, val)
 	}
-	marshaledNode, err := yaml.Marshal(&nodes)
-	gc.ExitIfError(err)
-	nodesFilePath := filepath.Join(getWorkingDir(), nodesFileName)
-	gc.ExitIfError(ioutil.WriteFile(nodesFilePath, marshaledNode, 0600))
+	writeNodesYml(nodes)
 	gc.ExitIfFalse(len(errMsgs) == 0, "Failed to install docker on some node(s)")
 }
 
 var dockerCmd = &cobra.Command{
-	Use:   "docker <arg1 arg2...> or not",
+	Use:   "docker [selector...]",
 	Short: "Install docker. Use -u flag to upgrade",
-	Long:  `Downloads and installs latest version of docker`,
+	Long: `Downloads and installs latest version of docker on selected nodes, all nodes by default.
+` + selectorHelp,
 	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
 		checkSSHAgent()
 		InstallDocker(forceUpgradeDocker, args)
 	}),
 }
 
-func installDocker(node node, client *SSHClient, upgrade bool) (node, error) {
+func installDocker(node node, client Executor, upgrade bool) (node, error) {
 	host := node.Host
 
 	version, err := getDockerVersion(host, client)
-	if err == nil && version != "" {
+	if err != nil {
+		return node, err
+	}
+	if version != "" {
 		if !upgrade {
 			logWithPrefix(host, fmt.Sprintf("Docker version [%s] already installed! Use -u flag to update docker to the latest version", version))
 			node.DockerVersion = version
@@ -115,28 +107,38 @@ func installDocker(node node, client *SSHClient, upgrade bool) (node, error) {
 
 	commands := []SSHCommand{
 		SSHCommand{
-			cmd:   "sudo apt-get update",
-			title: "Updating apt-get...",
+			cmd:     "sudo apt-get update",
+			title:   "Updating apt-get...",
+			timeout: aptCommandTimeout,
+			retry:   aptRetryPolicy,
 		},
 		SSHCommand{
-			cmd:   "sudo apt-get -y install apt-transport-https ca-certificates curl software-properties-common",
-			title: "Installing packages to allow apt to use a repository over HTTPS...",
+			cmd:     "sudo apt-get -y install apt-transport-https ca-certificates curl software-properties-common",
+			title:   "Installing packages to allow apt to use a repository over HTTPS...",
+			timeout: aptCommandTimeout,
+			retry:   aptRetryPolicy,
 		},
 		SSHCommand{
 			cmd:   "sudo curl -fsSL https://download.docker.com/linux/ubuntu/gpg | sudo apt-key add -",
 			title: "Add Docker’s official GPG key",
 		},
 		SSHCommand{
-			cmd:   "sudo add-apt-repository \"deb [arch=amd64] https://download.docker.com/linux/ubuntu $(lsb_release -cs) stable\"",
-			title: "Adding repository",
+			cmd:     "sudo add-apt-repository \"deb [arch=amd64] https://download.docker.com/linux/ubuntu $(lsb_release -cs) stable\"",
+			title:   "Adding repository",
+			timeout: aptCommandTimeout,
+			retry:   aptRetryPolicy,
 		},
 		SSHCommand{
-			cmd:   "sudo apt-get update",
-			title: "Updating apt-get...",
+			cmd:     "sudo apt-get update",
+			title:   "Updating apt-get...",
+			timeout: aptCommandTimeout,
+			retry:   aptRetryPolicy,
 		},
 		SSHCommand{
-			cmd:   "sudo apt-get -y install " + docker,
-			title: "Installing the latest version of " + docker,
+			cmd:     "sudo apt-get -y install " + docker,
+			title:   "Installing the latest version of " + docker,
+			timeout: aptCommandTimeout,
+			retry:   aptRetryPolicy,
 		},
 	}
 
@@ -149,6 +151,9 @@ func installDocker(node node, client *SSHClient, upgrade bool) (node, error) {
 	logWithPrefix(host, "Checking installation...")
 
 	version, err = getDockerVersion(host, client)
+	if err == nil && version == "" {
+		err = errors.New("docker not found after installation")
+	}
 	if err != nil {
 		node.DockerVersion = ""
 		return node, err
@@ -160,12 +165,18 @@ func installDocker(node node, client *SSHClient, upgrade bool) (node, error) {
 	return node, nil
 }
 
-func getDockerVersion(host string, client *SSHClient) (string, error) {
-	stdout, err := client.Exec(host, "docker -v")
+// getDockerVersion returns empty version if docker is not installed
+func getDockerVersion(host string, client Executor) (string, error) {
+	res, err := client.Run(host, "docker -v")
 
 	if err != nil {
 		return "", err
 	}
+	if res.ExitCode != 0 {
+		gc.Verbose("docker -v", res.Stderr)
+		return "", nil
+	}
+	stdout := res.Output()
 
 	version := ParseDockerVersion(stdout)
 
@@ -0,0 +1,49 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"github.com/spf13/cobra"
+	gc "github.com/untillpro/gochips"
+)
+
+// SetAvailability sets swarm availability of selected nodes, drained nodes don't run service tasks
+func SetAvailability(selector []string, availability string) {
+	firstEntry, clusterFile := getSwarmLeaderNodeAndClusterFile()
+	gc.ExitIfFalse(firstEntry != nil, "No manager node found!")
+	client := getSSHClient(clusterFile)
+	for _, node := range getNodesBySelector(client, selector...) {
+		client.ExecOrExit(firstEntry.node.Host, "sudo docker node update --availability "+availability+" \""+node.Alias+"\"")
+		logWithPrefix(node.Alias, "Availability set to "+availability)
+	}
+}
+
+var drainCmd = &cobra.Command{
+	Use:   "drain <selector...>",
+	Short: "Drains selected nodes, service tasks are moved to other nodes",
+	Long: `Sets availability of selected nodes to drain, e.g. before maintenance. Use activate command to return nodes back.
+` + selectorHelp,
+	Args: cobra.MinimumNArgs(1),
+	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
+		checkSSHAgent()
+		SetAvailability(args, "drain")
+	}),
+}
+
+var activateCmd = &cobra.Command{
+	Use:   "activate <selector...>",
+	Short: "Makes selected nodes available for service tasks again",
+	Long: `Sets availability of selected nodes to active.
+` + selectorHelp,
+	Args: cobra.MinimumNArgs(1),
+	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
+		checkSSHAgent()
+		SetAvailability(args, "active")
+	}),
+}
@@ -32,10 +32,12 @@ var eLKCmd = &cobra.Command{
 	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
 		checkSSHAgent()
 		firstEntry, clusterFile := getSwarmLeaderNodeAndClusterFile()
-		kibanaUser := readPasswordPrompt("Kibana login")
-		kibanaPass := readPasswordPrompt("Kibana password")
-		kibanaHashedPass := strings.Replace(hashPassword(kibanaPass), "$", "\\$\\$", -1)
-		clusterFile.KibanaCreds = fmt.Sprintf("%s:%s", kibanaUser, kibanaHashedPass)
+		if len(clusterFile.KibanaCreds) == 0 {
+			kibanaUser := getCredential(credKibanaUser, func() string { return readPasswordPrompt("Kibana login") })
+			kibanaPass := getCredential(credKibanaPassword, func() string { return readPasswordPrompt("Kibana password") })
+			clusterFile.KibanaCreds = fmt.Sprintf("%s:%s", kibanaUser, hashPassword(kibanaPass))
+		}
+		clusterFile.KibanaCreds = strings.Replace(clusterFile.KibanaCreds, "$", "\\$\\$", -1)
 		if !firstEntry.node.Traefik {
 			gc.Fatal("Need to deploy traefik before elk deploy")
 		}
@@ -50,8 +52,6 @@ func deployELKStack(clusterFile *clusterFile, firstEntry *entry) {
 		host:   firstEntry.node.Host,
 		client: client,
 	}
-	gc.Info("Installing dos2unix")
-	client.ExecOrExit(host, "sudo apt-get install dos2unix")
 	curDir := getSourcesDir()
 	copyToHost(&forCopy, filepath.ToSlash(filepath.Join(curDir, eLKPrefix)))
 	appliedBuffer := executeTemplateToFile(eLKComposeFileName, clusterFile)
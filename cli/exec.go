@@ -0,0 +1,165 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/spf13/cobra"
+	gc "github.com/untillpro/gochips"
+)
+
+var execNodesArg string
+
+// nodeExecResult is a result of the command executed on the node by `swarmgo exec`
+type nodeExecResult struct {
+	node node
+	res  *ExecResult
+	err  error
+}
+
+func (r *nodeExecResult) failed() bool {
+	return r.err != nil || r.res.ExitCode != 0
+}
+
+// remoteCommand returns args given after -- as a command, single arg is passed as is so pipes can be used
+func remoteCommand(args []string) string {
+	if len(args) == 1 {
+		return args[0]
+	}
+	return shellquote.Join(args...)
+}
+
+// writePrefixed writes every line of output prefixed by the node alias
+func writePrefixed(w io.Writer, prefix, output string) {
+	output = strings.TrimRight(output, "\r\n")
+	if len(output) == 0 {
+		return
+	}
+	for _, line := range strings.Split(output, "\n") {
+		fmt.Fprintf(w, "%s | %s\n", prefix, strings.TrimRight(line, "\r"))
+	}
+}
+
+// writeExecSummary writes table with exit code and duration of the command per node
+func writeExecSummary(w io.Writer, results []*nodeExecResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tHOST\tEXIT\tDURATION\tERROR")
+	for _, r := range results {
+		exitCode, duration, errMsg := "-", "-", ""
+		if r.res != nil {
+			exitCode = fmt.Sprint(r.res.ExitCode)
+			duration = r.res.Duration.Round(time.Millisecond).String()
+		}
+		if r.err != nil {
+			errMsg = r.err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.node.Alias, r.node.Host, exitCode, duration, errMsg)
+	}
+	tw.Flush()
+}
+
+// ExecOnNodes runs command on given nodes in parallel, output of every node is printed prefixed by its alias when the node finishes
+func ExecOnNodes(client Executor, nodes []node, command string) []*nodeExecResult {
+	width := 0
+	for _, n := range nodes {
+		if len(n.Alias) > width {
+			width = len(n.Alias)
+		}
+	}
+	ctx, cancel := timeoutContext(0)
+	defer cancel()
+	done := make(chan *nodeExecResult)
+	for _, n := range nodes {
+		go func(n node) {
+			res, err := client.RunContext(ctx, n.Host, command)
+			done <- &nodeExecResult{node: n, res: res, err: err}
+		}(n)
+	}
+	byAlias := make(map[string]*nodeExecResult)
+	for range nodes {
+		r := <-done
+		byAlias[r.node.Alias] = r
+		prefix := fmt.Sprintf("%-*s", width, r.node.Alias)
+		if r.res != nil {
+			writePrefixed(os.Stdout, prefix, r.res.Stdout)
+			writePrefixed(os.Stderr, prefix, r.res.Stderr)
+		}
+	}
+	results := make([]*nodeExecResult, 0, len(nodes))
+	for _, n := range nodes {
+		results = append(results, byAlias[n.Alias])
+	}
+	return results
+}
+
+var execCmd = &cobra.Command{
+	Use:   "exec --nodes <selector> -- <command>",
+	Short: "Runs command on selected nodes in parallel",
+	Long: `Runs command on nodes selected by --nodes option. Output is prefixed by node alias, summary with exit codes is printed when all nodes finish.
+` + selectorHelp,
+	Args: cobra.MinimumNArgs(1),
+	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
+		gc.ExitIfFalse(len(execNodesArg) > 0, "Nodes must be specified by --nodes option, e.g. --nodes all")
+		clusterFile := unmarshalClusterYml()
+		checkSSHAgent()
+		client := getSSHClient(clusterFile)
+		nodes := getNodesBySelector(client, execNodesArg)
+
+		results := ExecOnNodes(client, nodes, remoteCommand(args))
+		writeExecSummary(os.Stdout, results)
+		failed := 0
+		for _, r := range results {
+			if r.failed() {
+				failed++
+			}
+		}
+		gc.ExitIfFalse(failed == 0, fmt.Sprintf("Command failed on %d of %d node(s)", failed, len(results)))
+	}),
+}
+
+var sshCmd = &cobra.Command{
+	Use:   "ssh <alias> [-- <command>]",
+	Short: "Opens interactive SSH session on the node",
+	Long:  `Opens login shell on the node, or runs given command with tty attached. Remote exit code is returned`,
+	Args:  cobra.MinimumNArgs(1),
+	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
+		clusterFile := unmarshalClusterYml()
+		nodes := getNodesFromYml(getWorkingDir())
+		var target *node
+		for i := range nodes {
+			if nodes[i].Alias == args[0] {
+				target = &nodes[i]
+			}
+		}
+		gc.ExitIfFalse(target != nil, "Node not found in "+nodesFileName+": "+args[0])
+		command := ""
+		if len(args) > 1 {
+			command = remoteCommand(args[1:])
+		}
+
+		checkSSHAgent()
+		_, privateKeyFile := findSSHKeys(clusterFile)
+		client := getSSHClientInstance(clusterFile, clusterFile.ClusterUserName, privateKeyFile)
+		err := client.Interactive(target.Host, command)
+		var exitErr *remoteExitError
+		if errors.As(err, &exitErr) {
+			finitCommand()
+			os.Exit(exitErr.code)
+		}
+		gc.ExitIfError(err)
+	}),
+}
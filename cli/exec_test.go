@@ -0,0 +1,44 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecOutput(t *testing.T) {
+	var out bytes.Buffer
+	writePrefixed(&out, "node1", "line1\r\nline2\n\n")
+	if out.String() != "node1 | line1\nnode1 | line2\n" {
+		t.Errorf("Unexpected prefixed output: %q", out.String())
+	}
+
+	out.Reset()
+	writeExecSummary(&out, []*nodeExecResult{
+		{node: node{Alias: "node1", Host: "10.0.0.1"}, res: &ExecResult{ExitCode: 0, Duration: 1500 * time.Millisecond}},
+		{node: node{Alias: "node2", Host: "10.0.0.2"}, err: errors.New("dial tcp: timeout")},
+	})
+	expected := []string{
+		"NODE   HOST      EXIT  DURATION  ERROR",
+		"node1  10.0.0.1  0     1.5s      ",
+		"node2  10.0.0.2  -     -         dial tcp: timeout",
+	}
+	if !reflect.DeepEqual(strings.Split(strings.TrimRight(out.String(), "\n"), "\n"), expected) {
+		t.Errorf("Unexpected summary:\n%s", out.String())
+	}
+
+	if remoteCommand([]string{"docker ps | grep app"}) != "docker ps | grep app" || remoteCommand([]string{"echo", "a b"}) != "echo 'a b'" {
+		t.Error("Unexpected remote command")
+	}
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// Executor executes commands and copies files on cluster nodes. SSHClient is the real implementation
+type Executor interface {
+	Run(host string, command string) (*ExecResult, error)
+	RunContext(ctx context.Context, host string, command string) (*ExecResult, error)
+	// RunInput is like RunContext but input is given to the command on stdin, e.g. secrets which must not appear in the command line
+	RunInput(ctx context.Context, host string, command string, input io.Reader) (*ExecResult, error)
+	Exec(host string, command string) (string, error)
+	ExecContext(ctx context.Context, host string, command string) (string, error)
+	ExecOrExit(host string, command string) string
+	Copy(host string, size int64, mode os.FileMode, fileName string, contents io.Reader, destinationPath string) error
+	CopyPath(host string, filePath, destinationPath string) error
+	// SyncDir uploads files from local dir which differ from files in remote dir
+	SyncDir(host string, localDir, remoteDir string, options SyncOptions) (*SyncResult, error)
+	// Fetch runs command and streams its stdout to contents, e.g. to download files. Non-zero exit code is an error
+	Fetch(host string, command string, contents io.Writer) error
+	// HostKey returns fingerprint of the host key to be pinned for the host
+	HostKey(host string) (string, error)
+}
+
+// newExecutor creates executor for the user on cluster nodes, password is used if no private key given and for sudo prompts.
+// Replaced in tests to run flows without real nodes
+var newExecutor = func(file *clusterFile, userName, privateKeyFile, password string) Executor {
+	client := getSSHClientInstance(file, userName, privateKeyFile)
+	client.Password = password
+	if len(password) > 0 {
+		// Root user password answers its sudo prompts
+		client.SudoPassword = password
+	} else if file.SudoWithPassword && userName == file.ClusterUserName {
+		client.sudoPasswordPrompt = func() string { return getSudoPassword(file) }
+	}
+	// When neither key nor password specified, input might be expected from user
+	client.HideStdout = len(privateKeyFile) > 0 || len(password) > 0
+	return recordIfRequired(client)
+}
+
+// withNodes makes executor aware of nodes which are not in nodes.yml yet, e.g. nodes being added
+func withNodes(executor Executor, file *clusterFile, nodes ...node) Executor {
+	switch e := executor.(type) {
+	case *SSHClient:
+		e.setNodes(file, nodes)
+	case *recordingExecutor:
+		withNodes(e.executor, file, nodes...)
+	}
+	return executor
+}
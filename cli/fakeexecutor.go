@@ -0,0 +1,317 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sync"
+	"time"
+
+	gc "github.com/untillpro/gochips"
+	"gopkg.in/yaml.v2"
+)
+
+// recordEnvVar names fixture file to record executed commands to, e.g. SWARMGO_RECORD=imlucky.yml swarmgo imlucky ...
+const recordEnvVar = "SWARMGO_RECORD"
+
+const maskedValue = "**(masked)**"
+
+// fixtureEntry is a command with its canned result. Entries are recorded from real sessions or written by hand
+type fixtureEntry struct {
+	Host     string `yaml:"Host,omitempty"`    // Any host if empty
+	Command  string `yaml:"Command,omitempty"` // Exact command
+	Pattern  string `yaml:"Pattern,omitempty"` // Regular expression, used if Command is empty
+	Times    int    `yaml:"Times,omitempty"`   // How many times entry matches for each host, unlimited if zero
+	ExitCode int    `yaml:"ExitCode,omitempty"`
+	Stdout   string `yaml:"Stdout,omitempty"`
+	Stderr   string `yaml:"Stderr,omitempty"`
+}
+
+func (e *fixtureEntry) matches(host, command string) bool {
+	if len(e.Host) > 0 && e.Host != host {
+		return false
+	}
+	if len(e.Command) > 0 {
+		return e.Command == command
+	}
+	matched, err := regexp.MatchString(e.Pattern, command)
+	gc.ExitIfError(err, "Wrong fixture pattern "+e.Pattern)
+	return matched
+}
+
+// FakeExecutor replays canned results instead of executing commands on nodes.
+// Entries are matched in order, first matching entry which is not exhausted for the host wins
+type FakeExecutor struct {
+	sync.Mutex
+	entries  []fixtureEntry
+	used     map[string]int
+	Executed []ExecResult // Commands executed so far
+	Copied   []string     // Files copied so far as host:destination
+}
+
+func newFakeExecutor(entries ...fixtureEntry) *FakeExecutor {
+	return &FakeExecutor{
+		entries: entries,
+		used:    make(map[string]int),
+	}
+}
+
+// loadFakeExecutor creates FakeExecutor with entries from fixture file
+func loadFakeExecutor(fixtureFile string) (*FakeExecutor, error) {
+	bytes, err := ioutil.ReadFile(fixtureFile)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]fixtureEntry, 0)
+	if err := yaml.Unmarshal(bytes, &entries); err != nil {
+		return nil, err
+	}
+	return newFakeExecutor(entries...), nil
+}
+
+// RunContext returns canned result of the first matching entry, unexpected commands fail
+func (f *FakeExecutor) RunContext(ctx context.Context, host string, command string) (*ExecResult, error) {
+	command, _, _ = isMasked(command)
+	f.Lock()
+	defer f.Unlock()
+	res := &ExecResult{
+		Host:     host,
+		Command:  command,
+		ExitCode: -1,
+	}
+	for i, e := range f.entries {
+		key := fmt.Sprintf("%d/%s", i, host)
+		if !e.matches(host, command) || (e.Times > 0 && f.used[key] >= e.Times) {
+			continue
+		}
+		f.used[key]++
+		res.ExitCode = e.ExitCode
+		res.Stdout = e.Stdout
+		res.Stderr = e.Stderr
+		f.Executed = append(f.Executed, *res)
+		return res, nil
+	}
+	f.Executed = append(f.Executed, *res)
+	return res, fmt.Errorf("unexpected command on %s: %s", host, command)
+}
+
+// RunInput s.e., input is consumed and not checked
+func (f *FakeExecutor) RunInput(ctx context.Context, host string, command string, input io.Reader) (*ExecResult, error) {
+	if _, err := io.Copy(ioutil.Discard, input); err != nil {
+		return nil, err
+	}
+	return f.RunContext(ctx, host, command)
+}
+
+// Run s.e.
+func (f *FakeExecutor) Run(host string, command string) (*ExecResult, error) {
+	return f.RunContext(context.Background(), host, command)
+}
+
+// ExecContext s.e.
+func (f *FakeExecutor) ExecContext(ctx context.Context, host string, command string) (string, error) {
+	return execOutput(f.RunContext(ctx, host, command))
+}
+
+// Exec s.e.
+func (f *FakeExecutor) Exec(host string, command string) (string, error) {
+	return execOutput(f.Run(host, command))
+}
+
+// ExecOrExit s.e.
+func (f *FakeExecutor) ExecOrExit(host string, command string) string {
+	out, err := f.Exec(host, command)
+	gc.ExitIfError(err)
+	return out
+}
+
+// Copy consumes contents and remembers destination
+func (f *FakeExecutor) Copy(host string, size int64, mode os.FileMode, fileName string, contents io.Reader, destinationPath string) error {
+	if _, err := io.Copy(ioutil.Discard, contents); err != nil {
+		return err
+	}
+	f.Lock()
+	defer f.Unlock()
+	f.Copied = append(f.Copied, host+":"+destinationPath)
+	return nil
+}
+
+// CopyPath remembers destination, local file must exist
+func (f *FakeExecutor) CopyPath(host string, filePath, destinationPath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	s, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return f.Copy(host, s.Size(), s.Mode(), path.Base(filePath), file, destinationPath)
+}
+
+// SyncDir remembers destinations of local files, all files are taken as changed
+func (f *FakeExecutor) SyncDir(host string, localDir, remoteDir string, options SyncOptions) (*SyncResult, error) {
+	files, _, err := readSyncFiles(localDir, options.NormalizeLineEndings)
+	if err != nil {
+		return nil, err
+	}
+	f.Lock()
+	defer f.Unlock()
+	for _, file := range files {
+		f.Copied = append(f.Copied, host+":"+path.Join(remoteDir, file.path))
+	}
+	return &SyncResult{Uploaded: len(files)}, nil
+}
+
+// Fetch writes canned stdout of the first matching entry to contents
+func (f *FakeExecutor) Fetch(host string, command string, contents io.Writer) error {
+	res, err := f.Run(host, command)
+	if err != nil {
+		return err
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("exit status %d: %s", res.ExitCode, res.Stderr)
+	}
+	_, err = io.WriteString(contents, res.Stdout)
+	return err
+}
+
+// HostKey returns fake fingerprint
+func (f *FakeExecutor) HostKey(host string) (string, error) {
+	return "SHA256:fake-" + host, nil
+}
+
+// recordingExecutor executes commands by underlying executor and records results, so they can be replayed by FakeExecutor
+type recordingExecutor struct {
+	executor Executor
+}
+
+var recording = struct {
+	sync.Mutex
+	entries []fixtureEntry
+}{}
+
+func recordIfRequired(executor Executor) Executor {
+	if len(os.Getenv(recordEnvVar)) == 0 {
+		return executor
+	}
+	return &recordingExecutor{executor}
+}
+
+// RunContext s.e.
+func (r *recordingExecutor) RunContext(ctx context.Context, host string, command string) (*ExecResult, error) {
+	res, err := r.executor.RunContext(ctx, host, command)
+	r.record(host, command, res)
+	return res, err
+}
+
+// RunInput s.e., input is not recorded
+func (r *recordingExecutor) RunInput(ctx context.Context, host string, command string, input io.Reader) (*ExecResult, error) {
+	res, err := r.executor.RunInput(ctx, host, command, input)
+	r.record(host, command, res)
+	return res, err
+}
+
+// record records masked input and output as **(masked)**, such entries need to be edited before replay
+func (r *recordingExecutor) record(host string, command string, res *ExecResult) {
+	unmasked, maskInput, maskOutput := isMasked(command)
+	entry := fixtureEntry{
+		Host:     host,
+		Command:  unmasked,
+		Times:    1,
+		ExitCode: res.ExitCode,
+		Stdout:   res.Stdout,
+		Stderr:   res.Stderr,
+	}
+	if maskInput {
+		entry.Command = maskedValue
+	}
+	if maskOutput {
+		entry.Stdout = maskedValue
+	}
+	recording.Lock()
+	recording.entries = append(recording.entries, entry)
+	recording.Unlock()
+}
+
+// Run s.e.
+func (r *recordingExecutor) Run(host string, command string) (*ExecResult, error) {
+	res, err := r.executor.Run(host, command)
+	r.record(host, command, res)
+	return res, err
+}
+
+// ExecContext s.e.
+func (r *recordingExecutor) ExecContext(ctx context.Context, host string, command string) (string, error) {
+	return execOutput(r.RunContext(ctx, host, command))
+}
+
+// Exec s.e.
+func (r *recordingExecutor) Exec(host string, command string) (string, error) {
+	return execOutput(r.Run(host, command))
+}
+
+// ExecOrExit s.e.
+func (r *recordingExecutor) ExecOrExit(host string, command string) string {
+	out, err := r.Exec(host, command)
+	gc.ExitIfError(err)
+	return out
+}
+
+// Copy s.e.
+func (r *recordingExecutor) Copy(host string, size int64, mode os.FileMode, fileName string, contents io.Reader, destinationPath string) error {
+	return r.executor.Copy(host, size, mode, fileName, contents, destinationPath)
+}
+
+// CopyPath s.e.
+func (r *recordingExecutor) CopyPath(host string, filePath, destinationPath string) error {
+	return r.executor.CopyPath(host, filePath, destinationPath)
+}
+
+// SyncDir s.e., synced files are not recorded
+func (r *recordingExecutor) SyncDir(host string, localDir, remoteDir string, options SyncOptions) (*SyncResult, error) {
+	return r.executor.SyncDir(host, localDir, remoteDir, options)
+}
+
+// Fetch s.e., fetched contents are not recorded
+func (r *recordingExecutor) Fetch(host string, command string, contents io.Writer) error {
+	return r.executor.Fetch(host, command, contents)
+}
+
+// HostKey s.e.
+func (r *recordingExecutor) HostKey(host string) (string, error) {
+	return r.executor.HostKey(host)
+}
+
+// saveRecording appends entries recorded during the command to the fixture file
+func saveRecording() {
+	fixtureFile := os.Getenv(recordEnvVar)
+	recording.Lock()
+	defer recording.Unlock()
+	if len(fixtureFile) == 0 || len(recording.entries) == 0 {
+		return
+	}
+	bytes, err := yaml.Marshal(recording.entries)
+	gc.ExitIfError(err)
+	f, err := os.OpenFile(fixtureFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	gc.ExitIfError(err)
+	defer f.Close()
+	fmt.Fprintf(f, "# %s\n", time.Now().Format(time.RFC3339))
+	_, err = f.Write(bytes)
+	gc.ExitIfError(err)
+	gc.Info(fmt.Sprintf("%d commands recorded to %s", len(recording.entries), fixtureFile))
+	recording.entries = nil
+}
@@ -9,29 +9,51 @@
 package cli
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/x509"
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	gc "github.com/untillpro/gochips"
 
 	"golang.org/x/crypto/ssh"
 )
 
-func generatePrivateKey(bitSize int) (*rsa.PrivateKey, error) {
-	// Private Key generation
-	privateKey, err := rsa.GenerateKey(rand.Reader, bitSize)
-	if err != nil {
-		return nil, err
-	}
+const (
+	keyTypeEd25519 = "ed25519"
+	keyTypeRSA     = "rsa"
+	keyTypeECDSA   = "ecdsa"
+)
 
-	// Validate Private Key
-	err = privateKey.Validate()
+var keyTypes = []string{keyTypeEd25519, keyTypeRSA, keyTypeECDSA}
+
+const rsaKeyBitSize = 4096
+
+func generatePrivateKey(keyType string) (crypto.Signer, error) {
+	var privateKey crypto.Signer
+	var err error
+	switch keyType {
+	case keyTypeEd25519:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	case keyTypeRSA:
+		var rsaKey *rsa.PrivateKey
+		if rsaKey, err = rsa.GenerateKey(rand.Reader, rsaKeyBitSize); err == nil {
+			err = rsaKey.Validate()
+		}
+		privateKey = rsaKey
+	case keyTypeECDSA:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		err = fmt.Errorf("unknown key type %s, must be one of: %s", keyType, strings.Join(keyTypes, ", "))
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -40,29 +62,61 @@ func generatePrivateKey(bitSize int) (*rsa.PrivateKey, error) {
 	return privateKey, nil
 }
 
-// encodePrivateKeyToPEMAndEncrypt encodes Private Key from RSA to PEM format
-func encodePrivateKeyToPEMAndEncrypt(privateKey *rsa.PrivateKey, password string) []byte {
-	// Get ASN.1 DER format
-	privDER := x509.MarshalPKCS1PrivateKey(privateKey)
-
-	// Encrypt DER key to encrypted PEM block
-	privatePEM, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", privDER, []byte(password),
-		x509.PEMCipherDES)
-	gc.ExitIfError(err)
-
-	return pem.EncodeToMemory(privatePEM)
+// encodePrivateKey encodes Private Key to OpenSSH format, key is encrypted by AES with bcrypt KDF if password is not empty
+func encodePrivateKey(privateKey crypto.Signer, comment, password string) ([]byte, error) {
+	var block *pem.Block
+	var err error
+	if len(password) > 0 {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(privateKey, comment, []byte(password))
+	} else {
+		block, err = ssh.MarshalPrivateKey(privateKey, comment)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(block), nil
 }
 
-// generatePublicKey take a rsa.PublicKey and return bytes suitable for writing to .pub file
-// returns in the format "ssh-rsa ..."
-func generatePublicKey(privateKey *rsa.PublicKey) []byte {
-	publicRsaKey, err := ssh.NewPublicKey(privateKey)
-	gc.ExitIfError(err)
+// generatePublicKey takes a public key and returns it in the format suitable for writing to .pub file, e.g. "ssh-ed25519 ... comment"
+func generatePublicKey(publicKey crypto.PublicKey, comment string) (ssh.PublicKey, []byte, error) {
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	pubKeyBytes := ssh.MarshalAuthorizedKey(publicRsaKey)
+	pubKeyBytes := ssh.MarshalAuthorizedKey(sshPublicKey)
+	if len(comment) > 0 {
+		pubKeyBytes = append(pubKeyBytes[:len(pubKeyBytes)-1], []byte(" "+comment+"\n")...)
+	}
 
 	gc.Info("Public key generated")
-	return pubKeyBytes
+	return sshPublicKey, pubKeyBytes, nil
+}
+
+// readPublicKeyInfo returns type and fingerprint of the public key from .pub file
+func readPublicKeyInfo(publicKeyFile string) (keyType, fingerprint string, err error) {
+	bytes, err := ioutil.ReadFile(publicKeyFile)
+	if err != nil {
+		return "", "", err
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(bytes)
+	if err != nil {
+		return "", "", err
+	}
+	return publicKeyType(publicKey), ssh.FingerprintSHA256(publicKey), nil
+}
+
+// publicKeyType returns key type in terms of `keys --type` option
+func publicKeyType(publicKey ssh.PublicKey) string {
+	switch {
+	case publicKey.Type() == ssh.KeyAlgoED25519:
+		return keyTypeEd25519
+	case publicKey.Type() == ssh.KeyAlgoRSA:
+		return keyTypeRSA
+	case strings.HasPrefix(publicKey.Type(), "ecdsa-"):
+		return keyTypeECDSA
+	}
+	return publicKey.Type()
 }
 
 // writePemToFile writes keys to a file
@@ -80,29 +134,32 @@ func writeKeyToFile(keyBytes []byte, saveFileTo string) error {
 	return nil
 }
 
-// generate keys and write them to file, can return error
-func generateKeysAndWriteToFile(bitSize int, privateKeyFile, publicKeyFile, password string) error {
-	privateKey, err := generatePrivateKey(bitSize)
+// generate keys of given type and write them to files, returns fingerprint of the public key
+func generateKeysAndWriteToFile(keyType, comment, privateKeyFile, publicKeyFile, password string) (string, error) {
+	privateKey, err := generatePrivateKey(keyType)
 	if err != nil {
-		gc.Fatal(err.Error())
-		return err
+		return "", err
 	}
 
-	publicKeyBytes := generatePublicKey(&privateKey.PublicKey)
+	publicKey, publicKeyBytes, err := generatePublicKey(privateKey.Public(), comment)
+	if err != nil {
+		return "", err
+	}
 
-	privateKeyBytes := encodePrivateKeyToPEMAndEncrypt(privateKey, password)
+	privateKeyBytes, err := encodePrivateKey(privateKey, comment, password)
+	if err != nil {
+		return "", err
+	}
 
 	err = writeKeyToFile(privateKeyBytes, privateKeyFile)
 	if err != nil {
-		gc.Fatal(err.Error())
-		return err
+		return "", err
 	}
 
-	err = writeKeyToFile([]byte(publicKeyBytes), publicKeyFile)
+	err = writeKeyToFile(publicKeyBytes, publicKeyFile)
 	if err != nil {
-		gc.Fatal(err.Error())
-		return err
+		return "", err
 	}
 
-	return nil
+	return ssh.FingerprintSHA256(publicKey), nil
 }
@@ -0,0 +1,56 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGenerateKeysAndWriteToFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarmgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, keyType := range keyTypes {
+		privateKeyFile := filepath.Join(dir, keyType)
+		publicKeyFile := privateKeyFile + ".pub"
+		fingerprint, err := generateKeysAndWriteToFile(keyType, "test", privateKeyFile, publicKeyFile, "pass")
+		if err != nil {
+			t.Fatal(keyType, err)
+		}
+
+		pemBytes, err := ioutil.ReadFile(privateKeyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ssh.ParsePrivateKey(pemBytes); err == nil {
+			t.Error(keyType, "private key must be encrypted")
+		}
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte("pass"))
+		if err != nil {
+			t.Fatal(keyType, err)
+		}
+
+		pubType, pubFingerprint, err := readPublicKeyInfo(publicKeyFile)
+		if err != nil || pubType != keyType || pubFingerprint != fingerprint || ssh.FingerprintSHA256(signer.PublicKey()) != fingerprint {
+			t.Error("Unexpected public key info:", keyType, pubType, pubFingerprint, fingerprint, err)
+		}
+	}
+
+	if _, err := generateKeysAndWriteToFile("dsa", "test", filepath.Join(dir, "dsa"), filepath.Join(dir, "dsa.pub"), ""); err == nil {
+		t.Error("Unknown key type must fail")
+	}
+}
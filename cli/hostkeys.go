@@ -0,0 +1,261 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/spf13/cobra"
+	gc "github.com/untillpro/gochips"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v2"
+)
+
+// seenHostKeys keeps fingerprints of host keys accepted by trust-on-first-use during the command
+var seenHostKeys = struct {
+	sync.Mutex
+	byHost map[string]string
+}{byHost: make(map[string]string)}
+
+var errHostKeyScanned = errors.New("host key scanned")
+
+func rememberHostKey(host, fingerprint string) {
+	seenHostKeys.Lock()
+	defer seenHostKeys.Unlock()
+	seenHostKeys.byHost[host] = fingerprint
+}
+
+func getSeenHostKey(host string) string {
+	seenHostKeys.Lock()
+	defer seenHostKeys.Unlock()
+	return seenHostKeys.byHost[host]
+}
+
+// nodeHostKeyStore pins host keys of nodes from nodes.yml on first use, nodes of existing clusters have no HostKey
+type nodeHostKeyStore struct {
+	file string
+}
+
+// nodesYmlLock serializes updates of nodes.yml, host keys may be pinned by several connections of one command
+var nodesYmlLock sync.Mutex
+
+// pin saves fingerprint of the key seen first time to HostKey of the node, key pinned meanwhile must match.
+// Hosts missing in nodes.yml are not pinned, `swarmgo add` pins them when node is added
+func (s *nodeHostKeyStore) pin(host, fingerprint string) error {
+	nodesYmlLock.Lock()
+	defer nodesYmlLock.Unlock()
+	content, err := ioutil.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	nodes := make([]node, 0)
+	if err := yaml.Unmarshal(content, &nodes); err != nil {
+		return fmt.Errorf("unable to read %s: %v", nodesFileName, err)
+	}
+	for i, n := range nodes {
+		if n.Host != host {
+			continue
+		}
+		if len(n.HostKey) > 0 {
+			if n.HostKey != fingerprint {
+				return fmt.Errorf("host key mismatch for %s: %s is pinned in %s, but %s is offered", host, n.HostKey, nodesFileName, fingerprint)
+			}
+			return nil
+		}
+		nodes[i].HostKey = fingerprint
+		content, err := yaml.Marshal(&nodes)
+		if err != nil {
+			return err
+		}
+		logWithPrefix(host, "Host key pinned "+fingerprint)
+		return ioutil.WriteFile(s.file, content, 0600)
+	}
+	return nil
+}
+
+// writeNodesYml writes nodes to nodes.yml, host keys pinned by connections made during the command are kept
+func writeNodesYml(nodes []node) {
+	nodesYmlLock.Lock()
+	defer nodesYmlLock.Unlock()
+	pinned := make(map[string]string)
+	for _, n := range getNodesFromYml(getWorkingDir()) {
+		pinned[n.Host] = n.HostKey
+	}
+	for i := range nodes {
+		if len(nodes[i].HostKey) == 0 {
+			nodes[i].HostKey = pinned[nodes[i].Host]
+		}
+	}
+	marshaledNode, err := yaml.Marshal(&nodes)
+	gc.ExitIfError(err)
+	nodesFile := filepath.Join(getWorkingDir(), nodesFileName)
+	gc.ExitIfError(ioutil.WriteFile(nodesFile, marshaledNode, 0600))
+}
+
+// pinnedHostKeyCallback verifies host key against pinned fingerprint, unknown keys are accepted, remembered
+// and pinned if target persists them
+func pinnedHostKeyCallback(target *sshTarget) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint := ssh.FingerprintSHA256(key)
+		if len(target.hostKey) == 0 {
+			if target.pinHostKey != nil {
+				if err := target.pinHostKey(fingerprint); err != nil {
+					return err
+				}
+			}
+			rememberHostKey(target.host, fingerprint)
+			return nil
+		}
+		if fingerprint != target.hostKey {
+			gc.Error(fmt.Sprintf("REMOTE HOST IDENTIFICATION HAS CHANGED for %s! Someone could be eavesdropping on you right now (man-in-the-middle attack)", target.host))
+			return fmt.Errorf("host key mismatch for %s: %s key %s is pinned in %s, but %s is offered. If node was reinstalled use `swarmgo hostkeys` to pin new key",
+				target.host, key.Type(), target.hostKey, nodesFileName, fingerprint)
+		}
+		return nil
+	}
+}
+
+// scanHostKey returns fingerprint of the key offered by target host, no authentication is performed
+func scanHostKey(target *sshTarget) (string, error) {
+	key, err := scanHostPublicKey(target)
+	if err != nil {
+		return "", err
+	}
+	return ssh.FingerprintSHA256(key), nil
+}
+
+// scanHostPublicKey returns the key offered by target host, no authentication is performed
+func scanHostPublicKey(target *sshTarget) (ssh.PublicKey, error) {
+	var hostKey ssh.PublicKey
+	config := &ssh.ClientConfig{
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return errHostKeyScanned
+		},
+		Timeout: sshDialTimeout,
+	}
+	conn, err := (&nativeTransport{}).dialTCP(target)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_, _, _, err = ssh.NewClientConn(conn, conn.RemoteAddr().String(), config)
+	if hostKey != nil {
+		return hostKey, nil
+	}
+	return nil, err
+}
+
+// HostKey returns fingerprint accepted during the command, host is scanned if no connection was made by built-in client
+func (c *SSHClient) HostKey(host string) (string, error) {
+	if fingerprint := getSeenHostKey(host); len(fingerprint) > 0 {
+		return fingerprint, nil
+	}
+	return scanHostKey(c.target(host))
+}
+
+// PinHostKeys scans host keys of given nodes (all nodes if no aliases given) and pins them in nodes.yml
+func PinHostKeys(aliases []string) {
+	clusterFile := unmarshalClusterYml()
+	nodesFromYml := getNodesFromYml(getWorkingDir())
+	gc.ExitIfFalse(len(nodesFromYml) > 0, "Can't find nodes from nodes.yml. Add some nodes first")
+	_, privateKey := findSSHKeys(clusterFile)
+	client := getSSHClientInstance(clusterFile, clusterFile.ClusterUserName, privateKey)
+	for _, alias := range aliases {
+		found := false
+		for _, node := range nodesFromYml {
+			found = found || node.Alias == alias
+		}
+		gc.ExitIfFalse(found, alias+" missing in nodes.yml")
+	}
+	if len(aliases) == 0 {
+		// Nodes are reached through bastions, so bastions go first
+		repinBastionHostKeys(client, nodesFromYml)
+	}
+	for i, node := range nodesFromYml {
+		if len(aliases) > 0 && !contains(aliases, node.Alias) {
+			continue
+		}
+		fingerprint, err := scanHostKey(client.target(node.Host))
+		gc.ExitIfError(err, "Unable to get host key of "+node.Alias)
+		if fingerprint == node.HostKey {
+			logWithPrefix(node.Alias, "Host key unchanged "+fingerprint)
+			continue
+		}
+		if len(node.HostKey) > 0 {
+			logWithPrefix(node.Alias, fmt.Sprintf("Host key changed %s -> %s", node.HostKey, fingerprint))
+		} else {
+			logWithPrefix(node.Alias, "Host key pinned "+fingerprint)
+		}
+		nodesFromYml[i].HostKey = fingerprint
+	}
+	writeNodesYml(nodesFromYml)
+}
+
+// repinBastionHostKeys scans keys of bastions of the cluster and given nodes, every bastion is reached through already scanned ones.
+// Keys given by HostKey in swarmgo-config.yml or nodes.yml are verified only, they must be changed by hand
+func repinBastionHostKeys(client *SSHClient, nodes []node) {
+	chains := [][]bastionHost{client.Bastion}
+	for _, node := range nodes {
+		chains = append(chains, client.Nodes[node.Host].Bastion)
+	}
+	scanned := make(map[string]string)
+	for _, chain := range chains {
+		for _, jump := range bastionTargets(chain) {
+			address := net.JoinHostPort(jump.host, strconv.Itoa(jump.port))
+			if fingerprint, ok := scanned[address]; ok {
+				jump.hostKey = fingerprint
+				continue
+			}
+			fingerprint, err := scanHostKey(jump)
+			gc.ExitIfError(err, "Unable to get host key of bastion "+address)
+			configured := jump.hostKey
+			// Next bastions of the chain are reached through this one
+			jump.hostKey = fingerprint
+			scanned[address] = fingerprint
+			if len(configured) > 0 {
+				gc.ExitIfFalse(configured == fingerprint, fmt.Sprintf("Host key of bastion %s changed %s -> %s, update HostKey in %s or %s",
+					address, configured, fingerprint, swarmgoConfigFileName, nodesFileName))
+				logWithPrefix(address, "Host key unchanged "+fingerprint)
+				continue
+			}
+			pinned, err := client.bastionHostKeys.get(jump.host, jump.port)
+			gc.ExitIfError(err)
+			switch {
+			case pinned == fingerprint:
+				logWithPrefix(address, "Host key unchanged "+fingerprint)
+			case len(pinned) > 0:
+				logWithPrefix(address, fmt.Sprintf("Host key changed %s -> %s", pinned, fingerprint))
+			default:
+				logWithPrefix(address, "Host key pinned "+fingerprint)
+			}
+			gc.ExitIfError(client.bastionHostKeys.repin(jump.host, jump.port, fingerprint))
+		}
+	}
+}
+
+var hostKeysCmd = &cobra.Command{
+	Use:   "hostkeys [alias1 alias2...]",
+	Short: "Pin SSH host keys of nodes and bastions",
+	Long: `Scans SSH host keys of given nodes (all nodes if none specified) and pins their fingerprints in nodes.yml.
+If no nodes specified keys of bastions are re-pinned in ` + bastionHostKeysFileName + ` as well. Use after node or bastion is legitimately reinstalled`,
+	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
+		PinHostKeys(args)
+	}),
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestNodeHostKeyPinning(t *testing.T) {
+	file := filepath.Join(t.TempDir(), nodesFileName)
+	nodes := []node{{Host: "10.0.0.1", Alias: "node1"}, {Host: "10.0.0.2", Alias: "node2", HostKey: "SHA256:node2"}}
+	content, err := yaml.Marshal(&nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	client := Client("cluster", "/keys/cluster")
+	client.nodeHostKeys = &nodeHostKeyStore{file}
+	client.Nodes = map[string]node{"10.0.0.1": nodes[0], "10.0.0.2": nodes[1]}
+	if target := client.target("10.0.0.2"); target.pinHostKey != nil {
+		t.Error("Node with HostKey must not be pinned again")
+	}
+	target := client.target("10.0.0.1")
+	if target.pinHostKey == nil {
+		t.Fatal("Node without HostKey must be pinned on first use")
+	}
+	if err := target.pinHostKey("SHA256:node1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.pinHostKey("SHA256:other"); err == nil || !strings.Contains(err.Error(), "host key mismatch") {
+		t.Error("Key other than pinned one must be rejected:", err)
+	}
+	if err := client.nodeHostKeys.pin("10.0.0.3", "SHA256:node3"); err != nil {
+		t.Error("Host missing in nodes.yml must be skipped:", err)
+	}
+
+	content, err = ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned := make([]node, 0)
+	if err := yaml.Unmarshal(content, &pinned); err != nil {
+		t.Fatal(err)
+	}
+	if len(pinned) != 2 || pinned[0].HostKey != "SHA256:node1" || pinned[1].HostKey != "SHA256:node2" {
+		t.Error("Unexpected nodes.yml:", pinned)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// copyDir copies files of src dir to dst recursively
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode()|0700)
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, content, info.Mode())
+	})
+}
+
+// TestImLucky runs imlucky on three nodes against canned results from testdata/imlucky.yml
+func TestImLucky(t *testing.T) {
+	fake, err := loadFakeExecutor(filepath.Join("testdata", "imlucky.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoDir, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpDir, err := ioutil.TempDir("", "swarmgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	for _, dir := range []string{"scripts", "traefik", "swarmprom"} {
+		if err := copyDir(filepath.Join(repoDir, dir), filepath.Join(tmpDir, dir)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	workingDir := filepath.Join(tmpDir, "nodes")
+	if err := os.MkdirAll(workingDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	config := executeTemplateToFile(filepath.Join(repoDir, "cli", swarmgoConfigFileName), clusterFile{OrganizationName: "test", ClusterName: "test"})
+	publicKeyFile := filepath.Join(tmpDir, "test.pub")
+	config.WriteString("\nPublicKey: " + publicKeyFile + "\nPrivateKey: " + filepath.Join(tmpDir, "test") + "\n")
+	if err := ioutil.WriteFile(filepath.Join(workingDir, swarmgoConfigFileName), config.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(publicKeyFile, []byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIFake test"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func(f func(*clusterFile, string, string, string) Executor) { newExecutor = f }(newExecutor)
+	newExecutor = func(*clusterFile, string, string, string) Executor { return fake }
+
+	ImLucky([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, "pas", false, "mon", true, "")
+
+	for _, res := range fake.Executed {
+		if res.ExitCode < 0 {
+			t.Error("Unexpected command on", res.Host, res.Command)
+		}
+	}
+
+	nodes := getNodesFromYml(workingDir)
+	if len(nodes) != 3 {
+		t.Fatal("Expected 3 nodes, got:", nodes)
+	}
+	for _, n := range nodes {
+		if n.DockerVersion != "19.03.2" {
+			t.Error("Unexpected docker version on", n.Alias, n.DockerVersion)
+		}
+		if n.HostKey != "SHA256:fake-"+n.Host {
+			t.Error("Host key not pinned for", n.Alias, n.HostKey)
+		}
+		expectedMode := map[string]string{"node1": leader, "node2": manager, "node3": manager}[n.Alias]
+		if n.SwarmMode != expectedMode {
+			t.Error("Expected", n.Alias, "to be", expectedMode, "got:", n.SwarmMode)
+		}
+		if n.Traefik != (n.Alias == "node1") {
+			t.Error("Unexpected Traefik flag on", n.Alias, n.Traefik)
+		}
+	}
+
+	deployed := make([]string, 0)
+	for _, res := range fake.Executed {
+		if strings.HasPrefix(res.Command, "sudo docker stack deploy") {
+			deployed = append(deployed, res.Host+": "+res.Command)
+		}
+	}
+	expected := []string{
+		"10.0.0.1: sudo docker stack deploy -c traefik/traefik.yml traefik",
+		"10.0.0.1: sudo docker stack deploy -c swarmprom/swarmprom.yml prom",
+	}
+	if strings.Join(deployed, "\n") != strings.Join(expected, "\n") {
+		t.Error("Unexpected stacks deployed:", deployed)
+	}
+	if !contains(fake.Copied, "10.0.0.1:swarmprom/prometheus/rules/swarm_node.rules.yml") {
+		t.Error("Swarmprom files must be synced to the leader, got:", fake.Copied)
+	}
+}
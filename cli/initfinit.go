@@ -8,9 +8,14 @@
 package cli
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -19,6 +24,13 @@ import (
 
 var logFile *os.File
 
+var argTimeout time.Duration
+
+// cmdContext is cancelled when user interrupts the command
+var cmdContext = context.Background()
+var cancelCmdContext context.CancelFunc = func() {}
+var interrupts chan os.Signal
+
 type loggedRunnable func(cmd *cobra.Command, args []string)
 
 func loggedCmd(f loggedRunnable) func(cmd *cobra.Command, args []string) {
@@ -43,19 +55,70 @@ func initCommand(cmdName string) {
 	var err error
 	logFile, err = os.OpenFile(logFilePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
 	gc.ExitIfError(err, "Could not create a log file "+logFilePath)
+	openTranscripts(filepath.Join(logFolderName, n.Format("20060102-150405-")+cmdName))
 
+	cmdContext, cancelCmdContext = context.WithCancel(context.Background())
+	interrupts = make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	go handleInterrupts(interrupts)
+}
+
+// handleInterrupts cancels remote commands on first interrupt, so gathered state can be saved, and exits on second one
+func handleInterrupts(signals chan os.Signal) {
+	if _, ok := <-signals; !ok {
+		return
+	}
+	gc.Info("Interrupted, stopping remote commands. Interrupt again to exit immediately")
+	cancelCmdContext()
+	if _, ok := <-signals; ok {
+		os.Exit(1)
+	}
+}
+
+func commandContext() context.Context {
+	return cmdContext
+}
+
+// timeoutContext returns command context limited by timeout, --timeout flag takes precedence over given value
+func timeoutContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if argTimeout > 0 {
+		timeout = argTimeout
+	}
+	if timeout > 0 {
+		return context.WithTimeout(cmdContext, timeout)
+	}
+	return context.WithCancel(cmdContext)
+}
+
+func contextError(ctx context.Context, elapsed time.Duration) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %v", elapsed.Round(time.Second))
+	}
+	return errors.New("cancelled by user")
 }
 
 func finitCommand() {
+	if nil != interrupts {
+		signal.Stop(interrupts)
+		close(interrupts)
+		interrupts = nil
+	}
+	cancelCmdContext()
+	closeSSHConnections()
+	saveRecording()
+	closeTranscripts()
 	if nil != logFile {
 		logFile.Close()
 		logFile = nil
 	}
 }
 
+// consoleOutput receives command output, commands which print results for shell eval use os.Stderr
+var consoleOutput io.Writer = os.Stdout
+
 func myOutput(funcName, s string) {
 	if "Verbose" != funcName || verbose {
-		fmt.Print(s)
+		fmt.Fprint(consoleOutput, s)
 	}
 	n := time.Now()
 	line := n.Format("20060102 15:04:05.000 ") + s
@@ -9,23 +9,26 @@
 package cli
 
 import (
+	"strings"
+
 	"github.com/spf13/cobra"
 	gc "github.com/untillpro/gochips"
 )
 
 var privateKeyArg string
 var publicKeyArg string
+var keyTypeArg string
 
 var keysCmd = &cobra.Command{
 	Use:   "keys",
 	Short: "Initialize keys for ssh access",
-	Long:  `Generate new keys or specify keys for ssh access configuration.`,
+	Long:  `Generate new keys or specify keys for ssh access configuration. Keys are generated in OpenSSH format, type and fingerprint are kept in configuration file.`,
 	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
 
 		gc.Doing("Reading config")
 
 		readWorkingFileIfExists(swarmgoConfigFileName, "Config file not found, to create it run `swarmgo init`")
-		clusterFile := unmarshalClusterYml()
+		clusterFile := unmarshalClusterYmlForUpdate()
 
 		gc.Doing("Checking keys")
 
@@ -33,12 +36,17 @@ var keysCmd = &cobra.Command{
 			gc.ExitIfFalse(privateKeyArg != "" && publicKeyArg != "", "Both private and public keys need to be specified")
 			gc.ExitIfFalse(FileExists(privateKeyArg), "Specified private key doesn't exist")
 			gc.ExitIfFalse(FileExists(publicKeyArg), "Specified public key doesn't exist")
+			keyType, fingerprint, err := readPublicKeyInfo(publicKeyArg)
+			gc.ExitIfError(err, "Unable to read public key from "+publicKeyArg)
 			clusterFile.PrivateKey = privateKeyArg
 			clusterFile.PublicKey = publicKeyArg
+			clusterFile.KeyType = keyType
+			clusterFile.KeyFingerprint = fingerprint
 			marshalClusterYml(clusterFile)
 			gc.Info("Private and public keys updated in configuration file: " + swarmgoConfigFileName)
 			gc.Info("PrivateKey: " + clusterFile.PrivateKey)
 			gc.Info("PublicKey: " + clusterFile.PublicKey)
+			gc.Info("Fingerprint: " + clusterFile.KeyFingerprint)
 		} else {
 			publicKeyFile, privateKeyFile := findSSHKeys(clusterFile)
 			gc.Info("Public Key location:", publicKeyFile)
@@ -47,11 +55,15 @@ var keysCmd = &cobra.Command{
 			filesExist := FileExists(publicKeyFile) && FileExists(privateKeyFile)
 
 			if !filesExist {
-				gc.Doing("Generating new keys")
+				gc.ExitIfFalse(contains(keyTypes, keyTypeArg), "Key type must be one of: "+strings.Join(keyTypes, ", "))
+				gc.Doing("Generating new " + keyTypeArg + " keys")
 				passToKey := readKeyPassword()
-				bitSize := 4096
-				err := generateKeysAndWriteToFile(bitSize, privateKeyFile, publicKeyFile, passToKey)
+				fingerprint, err := generateKeysAndWriteToFile(keyTypeArg, clusterFile.ClusterName, privateKeyFile, publicKeyFile, passToKey)
 				gc.ExitIfError(err)
+				clusterFile.KeyType = keyTypeArg
+				clusterFile.KeyFingerprint = fingerprint
+				marshalClusterYml(clusterFile)
+				gc.Info("Fingerprint: " + fingerprint)
 			} else {
 				gc.Info("Keys already configured")
 			}
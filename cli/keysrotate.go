@@ -0,0 +1,241 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/spf13/cobra"
+	gc "github.com/untillpro/gochips"
+	"golang.org/x/crypto/ssh"
+)
+
+var rotateKeyTypeArg string
+
+const authorizedKeysFile = "~/.ssh/authorized_keys"
+
+// authorizedKey is a public key as it is written to authorized_keys
+type authorizedKey struct {
+	line string // Whole line, e.g. "ssh-ed25519 AAAA... comment"
+	blob string // Base64 encoded key, identifies the key regardless of the comment
+}
+
+func readAuthorizedKey(publicKeyFile string) (*authorizedKey, error) {
+	bytes, err := ioutil.ReadFile(publicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &authorizedKey{
+		line: strings.TrimSpace(string(bytes)),
+		blob: base64.StdEncoding.EncodeToString(publicKey.Marshal()),
+	}, nil
+}
+
+func addAuthorizedKeyCmd(key *authorizedKey) string {
+	return fmt.Sprintf("mkdir -p ~/.ssh && touch %[1]s && (grep -qF %[2]s %[1]s || echo %[3]s >> %[1]s)",
+		authorizedKeysFile, shellquote.Join(key.blob), shellquote.Join(key.line))
+}
+
+// removeAuthorizedKeyCmd rewrites authorized_keys in place, so owner and permissions are kept
+func removeAuthorizedKeyCmd(key *authorizedKey) string {
+	return fmt.Sprintf("grep -vF %[2]s %[1]s > %[1]s.swarmgo; cat %[1]s.swarmgo > %[1]s && rm %[1]s.swarmgo",
+		authorizedKeysFile, shellquote.Join(key.blob))
+}
+
+// keyRotation replaces the key on nodes and bastions. Old key is removed only after the new one is verified on all nodes
+type keyRotation struct {
+	nodes     []node
+	oldKey    *authorizedKey
+	newKey    *authorizedKey
+	oldClient Executor // Authenticates by old key
+	newClient Executor // Authenticates by new key only
+	added     []node   // Nodes where new key is added
+	removed   []node   // Nodes where old key is removed
+}
+
+func (r *keyRotation) run() error {
+	for _, n := range r.nodes {
+		logWithPrefix(n.Host, "Adding new key...")
+		if _, err := r.oldClient.Exec(n.Host, addAuthorizedKeyCmd(r.newKey)); err != nil {
+			return fmt.Errorf("unable to add new key to %s: %v", n.Alias, err)
+		}
+		r.added = append(r.added, n)
+	}
+	for _, n := range r.nodes {
+		logWithPrefix(n.Host, "Verifying login with new key...")
+		if _, err := r.newClient.Exec(n.Host, "whoami"); err != nil {
+			return fmt.Errorf("unable to login to %s with new key: %v", n.Alias, err)
+		}
+	}
+	for _, n := range r.nodes {
+		logWithPrefix(n.Host, "Removing old key...")
+		if _, err := r.newClient.Exec(n.Host, removeAuthorizedKeyCmd(r.oldKey)); err != nil {
+			return fmt.Errorf("unable to remove old key from %s: %v", n.Alias, err)
+		}
+		r.removed = append(r.removed, n)
+	}
+	return nil
+}
+
+// rollback returns old key to nodes where it is removed and removes new key, nodes which can't be rolled back are returned
+func (r *keyRotation) rollback() []string {
+	failed := make([]string, 0)
+	for _, n := range r.removed {
+		logWithPrefix(n.Host, "Restoring old key...")
+		if _, err := r.newClient.Exec(n.Host, addAuthorizedKeyCmd(r.oldKey)); err != nil {
+			gc.Error(n.Alias, "unable to restore old key:", err)
+			failed = append(failed, n.Alias)
+		}
+	}
+	for _, n := range r.added {
+		logWithPrefix(n.Host, "Removing new key...")
+		if _, err := r.oldClient.Exec(n.Host, removeAuthorizedKeyCmd(r.newKey)); err != nil {
+			gc.Error(n.Alias, "unable to remove new key:", err)
+			failed = append(failed, n.Alias)
+		}
+	}
+	return failed
+}
+
+// rotatedNodes returns nodes reached by the cluster key, nodes with own SSHKey are skipped since
+// login with the new key can't be verified on them
+func rotatedNodes(nodes []node) ([]node, []node) {
+	rotated, skipped := make([]node, 0, len(nodes)), make([]node, 0)
+	for _, n := range nodes {
+		if len(n.SSHKey) > 0 {
+			skipped = append(skipped, n)
+		} else {
+			rotated = append(rotated, n)
+		}
+	}
+	return rotated, skipped
+}
+
+// rotatedBastions returns bastions reached by the cluster key as nodes, in chain order. Each bastion is reached
+// through the bastions preceding it, so the new key is added to it before it is verified on the nodes behind it
+func rotatedBastions(file *clusterFile, nodes []node) []node {
+	res := make([]node, 0)
+	seen := make(map[string]bool)
+	chains := [][]bastionHost{file.Bastion}
+	for _, n := range nodes {
+		chains = append(chains, n.Bastion)
+	}
+	for _, chain := range chains {
+		for _, b := range withBastionDefaults(chain, file.ClusterUserName, "") {
+			if len(b.PrivateKey) > 0 {
+				continue
+			}
+			address := b.User + "@" + net.JoinHostPort(b.Host, strconv.Itoa(b.Port))
+			if seen[address] {
+				continue
+			}
+			seen[address] = true
+			res = append(res, node{Host: b.Host, Alias: "bastion " + address, SSHPort: b.Port, Bastion: chain})
+		}
+	}
+	return res
+}
+
+// identitiesOnly makes executor authenticate by its key file only, so login by keys loaded to ssh-agent is not taken as success
+func identitiesOnly(executor Executor) Executor {
+	switch e := executor.(type) {
+	case *SSHClient:
+		e.IdentitiesOnly = true
+	case *recordingExecutor:
+		identitiesOnly(e.executor)
+	}
+	return executor
+}
+
+// RotateKeys generates new cluster key pair and replaces the old key on all nodes from nodes.yml
+func RotateKeys(keyType, passphrase string) {
+	clusterFile := unmarshalClusterYmlForUpdate()
+	nodes, skipped := rotatedNodes(getNodesFromYml(getWorkingDir()))
+	for _, n := range skipped {
+		gc.Info(n.Alias + " uses own key " + n.SSHKey + ", skipped")
+	}
+	gc.ExitIfFalse(len(nodes) > 0, "No nodes use cluster key, use `swarmgo keys` to replace keys")
+	// Bastions without own key are reached by the cluster key, so the key is replaced on them too
+	bastions := rotatedBastions(clusterFile, getNodesFromYml(getWorkingDir()))
+	for _, b := range bastions {
+		gc.Info(b.Alias + " uses cluster key, key is replaced on it as well")
+	}
+
+	oldPublicKeyFile, oldPrivateKeyFile := findSSHKeys(clusterFile)
+	oldKey, err := readAuthorizedKey(oldPublicKeyFile)
+	gc.ExitIfError(err, "Unable to read public key from "+oldPublicKeyFile)
+
+	gc.Doing("Generating new " + keyType + " keys")
+	newPrivateKeyFile := filepath.Join(filepath.Dir(oldPrivateKeyFile), clusterFile.ClusterName+"-"+time.Now().Format("20060102-150405"))
+	newPublicKeyFile := newPrivateKeyFile + ".pub"
+	fingerprint, err := generateKeysAndWriteToFile(keyType, clusterFile.ClusterName, newPrivateKeyFile, newPublicKeyFile, passphrase)
+	gc.ExitIfError(err)
+	rememberKeyPassphrase(newPrivateKeyFile, passphrase)
+	newKey, err := readAuthorizedKey(newPublicKeyFile)
+	gc.ExitIfError(err)
+
+	gc.Doing("Replacing keys on nodes")
+	rotation := &keyRotation{
+		nodes:     append(bastions, nodes...),
+		oldKey:    oldKey,
+		newKey:    newKey,
+		oldClient: withNodes(newExecutor(clusterFile, clusterFile.ClusterUserName, oldPrivateKeyFile, ""), clusterFile, bastions...),
+		newClient: withNodes(identitiesOnly(newExecutor(clusterFile, clusterFile.ClusterUserName, newPrivateKeyFile, "")), clusterFile, bastions...),
+	}
+	if err := rotation.run(); err != nil {
+		gc.Error(err)
+		gc.Doing("Rolling back")
+		failed := rotation.rollback()
+		os.Remove(newPrivateKeyFile)
+		os.Remove(newPublicKeyFile)
+		gc.ExitIfFalse(len(failed) == 0, "Rollback failed, check authorized_keys on nodes:", strings.Join(failed, ", "))
+		gc.Fatal("Keys are not rotated, old key is still used")
+	}
+
+	clusterFile.PrivateKey = newPrivateKeyFile
+	clusterFile.PublicKey = newPublicKeyFile
+	clusterFile.KeyType = keyType
+	clusterFile.KeyFingerprint = fingerprint
+	marshalClusterYml(clusterFile)
+	gc.Info("Keys rotated on all nodes, new keys are kept in configuration file: " + swarmgoConfigFileName)
+	gc.Info("Fingerprint: " + fingerprint)
+	gc.Info("Old keys are not used anymore and can be deleted: " + oldPrivateKeyFile + ", " + oldPublicKeyFile)
+	gc.Info("Run eval `swarmgo agent` to load new key to ssh-agent")
+}
+
+var keysRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace cluster keys on all nodes",
+	Long:  `Generate new keys, add new public key to all nodes and bastions reached by the cluster key and verify login with it, then remove old key. Nothing is changed if any node fails.`,
+	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
+		readWorkingFileIfExists(swarmgoConfigFileName, "Config file not found, to create it run `swarmgo init`")
+		keyType := rotateKeyTypeArg
+		if len(keyType) == 0 {
+			keyType = unmarshalClusterYmlForUpdate().KeyType
+		}
+		if len(keyType) == 0 {
+			keyType = keyTypeEd25519
+		}
+		gc.ExitIfFalse(contains(keyTypes, keyType), "Key type must be one of: "+strings.Join(keyTypes, ", "))
+		RotateKeys(keyType, readKeyPassword())
+	}),
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKeyRotationRollback(t *testing.T) {
+	oldKey := &authorizedKey{line: "ssh-rsa OLD", blob: "OLD"}
+	newKey := &authorizedKey{line: "ssh-ed25519 NEW test", blob: "NEW"}
+	nodes := []node{{Host: "10.0.0.1", Alias: "node1"}, {Host: "10.0.0.2", Alias: "node2"}}
+
+	oldClient := newFakeExecutor(
+		fixtureEntry{Pattern: "^mkdir -p ~/.ssh .* NEW .*'ssh-ed25519 NEW test'"},
+		fixtureEntry{Pattern: "^grep -vF NEW "},
+	)
+	newClient := newFakeExecutor(
+		fixtureEntry{Host: "10.0.0.1", Command: "whoami", Stdout: "cluster\n"},
+		fixtureEntry{Host: "10.0.0.2", Command: "whoami", ExitCode: 255, Stderr: "Permission denied (publickey)"},
+	)
+	r := &keyRotation{nodes: nodes, oldKey: oldKey, newKey: newKey, oldClient: oldClient, newClient: newClient}
+
+	err := r.run()
+	if err == nil || !strings.Contains(err.Error(), "node2") {
+		t.Fatal("Failed verification on node2 expected, got:", err)
+	}
+	if len(r.added) != 2 || len(r.removed) != 0 {
+		t.Fatal("Unexpected rotation state:", r.added, r.removed)
+	}
+	if failed := r.rollback(); len(failed) != 0 {
+		t.Error("Rollback must succeed, failed on:", failed)
+	}
+
+	removed := make([]string, 0)
+	for _, res := range oldClient.Executed {
+		if strings.HasPrefix(res.Command, "grep -vF NEW") {
+			removed = append(removed, res.Host)
+		}
+	}
+	if strings.Join(removed, ",") != "10.0.0.1,10.0.0.2" {
+		t.Error("New key must be removed from all nodes, removed from:", removed)
+	}
+	for _, res := range newClient.Executed {
+		if strings.Contains(res.Command, "OLD") {
+			t.Error("Old key must not be touched:", res.Command)
+		}
+	}
+}
+
+func TestKeyRotationTargets(t *testing.T) {
+	file := &clusterFile{ClusterName: "test", ClusterUserName: "cluster", PublicKey: "/keys/old.pub", PrivateKey: "/keys/old",
+		Bastion: []bastionHost{{Host: "bastion"}, {Host: "bastion2", PrivateKey: "/keys/bastion2"}}}
+	nodes := []node{{Host: "10.0.0.1", Alias: "node1"}, {Host: "10.0.0.2", Alias: "node2", SSHKey: "/keys/node2"}}
+
+	client := Client(file.ClusterUserName, "/keys/new")
+	client.Bastion = withBastionDefaults(file.Bastion, file.ClusterUserName, bastionKey(file, client.User, client.PrivateKeyFile))
+	client.Nodes = make(map[string]node)
+	client.setNodes(file, nodes)
+	jumps := client.target("10.0.0.1").jumps
+	if len(jumps) != 2 || jumps[0].privateKeyFile != "/keys/new" || jumps[1].privateKeyFile != "/keys/bastion2" {
+		t.Error("Bastion without own key must be reached by the new key:", jumps[0], jumps[1])
+	}
+	if key := bastionKey(file, "root", ""); key != "/keys/old" {
+		t.Error("Bastion of other users must be reached by cluster key:", key)
+	}
+
+	bastions := rotatedBastions(file, append(nodes, node{Host: "10.0.1.1", Alias: "node3",
+		Bastion: []bastionHost{{Host: "bastion"}, {Host: "bastion3", User: "jump", Port: 2222}}}))
+	if len(bastions) != 2 || bastions[0].Alias != "bastion cluster@bastion:22" || bastions[1].Alias != "bastion jump@bastion3:2222" {
+		t.Fatal("Bastions without own key must be rotated once:", bastions)
+	}
+	client.setNodes(file, bastions)
+	if target := client.target("bastion"); len(target.jumps) != 0 || target.user != "cluster" || target.privateKeyFile != "/keys/new" {
+		t.Error("First bastion must be reached directly by the new key:", target)
+	}
+	if target := client.target("bastion3"); len(target.jumps) != 1 || target.jumps[0].host != "bastion" || target.user != "jump" || target.port != 2222 {
+		t.Error("Bastion must be reached through preceding bastions:", target)
+	}
+	client.IdentitiesOnly = true
+	if jumps := client.target("10.0.0.1").jumps; !jumps[0].identitiesOnly {
+		t.Error("Bastions must not be passed by keys from ssh-agent when client uses own key only")
+	}
+
+	rotated, skipped := rotatedNodes(nodes)
+	if len(rotated) != 1 || rotated[0].Alias != "node1" || len(skipped) != 1 || skipped[0].Alias != "node2" {
+		t.Error("Nodes with own key must be skipped:", rotated, skipped)
+	}
+}
@@ -91,14 +91,15 @@ func LabelList() {
 	}
 }
 
-// LabelAdd adds label to a node in cluster
-func LabelAdd(node string, label string) {
+// LabelAdd adds label to selected nodes in cluster
+func LabelAdd(selector string, label string) {
 	firstEntry, clusterFile := getSwarmLeaderNodeAndClusterFile()
-	nodesList := getNodesFromYml(getWorkingDir())
-	gc.ExitIfFalse(len(nodesList) > 0, "No nodes found in nodes.yml")
+	gc.ExitIfFalse(firstEntry != nil, "No manager node found!")
 	client := getSSHClient(clusterFile)
-	client.ExecOrExit(firstEntry.node.Host, fmt.Sprintf("sudo docker node update \"%s\" --label-add \"%s\"", node, label))
-	gc.Info(fmt.Sprintf("Added label \"%s\" to node \"%s\"", label, node))
+	for _, node := range getNodesBySelector(client, selector) {
+		client.ExecOrExit(firstEntry.node.Host, fmt.Sprintf("sudo docker node update \"%s\" --label-add \"%s\"", node.Alias, label))
+		gc.Info(fmt.Sprintf("Added label \"%s\" to node \"%s\"", label, node.Alias))
+	}
 }
 
 var labelLsCmd = &cobra.Command{
@@ -112,10 +113,11 @@ var labelLsCmd = &cobra.Command{
 }
 
 var labelAddCmd = &cobra.Command{
-	Use:   "add [node] [label]",
-	Short: "Adds label to node",
-	Long:  `Adds label to swarmgo node`,
-	Args:  cobra.ExactArgs(2),
+	Use:   "add [selector] [label]",
+	Short: "Adds label to nodes",
+	Long: `Adds label to selected swarmgo nodes.
+` + selectorHelp,
+	Args: cobra.ExactArgs(2),
 	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
 		checkSSHAgent()
 		LabelAdd(args[0], args[1])
@@ -123,17 +125,19 @@ var labelAddCmd = &cobra.Command{
 }
 
 var labelRmCmd = &cobra.Command{
-	Use:   "rm [node] [label]",
+	Use:   "rm [selector] [label]",
 	Short: "Removes node label",
-	Long:  `Removes swarmgo node label`,
-	Args:  cobra.MinimumNArgs(2),
+	Long: `Removes label from selected swarmgo nodes.
+` + selectorHelp,
+	Args: cobra.MinimumNArgs(2),
 	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
 		checkSSHAgent()
 		firstEntry, clusterFile := getSwarmLeaderNodeAndClusterFile()
-		nodesList := getNodesFromYml(getWorkingDir())
-		gc.ExitIfFalse(len(nodesList) > 0, "No nodes found in nodes.yml")
+		gc.ExitIfFalse(firstEntry != nil, "No manager node found!")
 		client := getSSHClient(clusterFile)
-		client.ExecOrExit(firstEntry.node.Host, "sudo docker node update \""+args[0]+"\" --label-rm \""+args[1]+"\"")
+		for _, node := range getNodesBySelector(client, args[0]) {
+			client.ExecOrExit(firstEntry.node.Host, "sudo docker node update \""+node.Alias+"\" --label-rm \""+args[1]+"\"")
+		}
 		gc.Info("ok")
 	}),
 }
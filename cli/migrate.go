@@ -0,0 +1,258 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	gc "github.com/untillpro/gochips"
+	"gopkg.in/yaml.v2"
+)
+
+// currentConfigVersion is the format version of swarmgo-config.yml and nodes.yml written by this swarmgo,
+// files without ConfigVersion have version 0
+const currentConfigVersion = 1
+
+var migrateDryRunArg bool
+
+var configVersionRegexp = regexp.MustCompile(`(?m)^ConfigVersion\s*:.*$`)
+
+// migration upgrades cluster files from Version-1 to Version, Config keeps comments of swarmgo-config.yml
+type migration struct {
+	Version     int
+	Description string
+	Config      func(content []byte) ([]byte, error) // Nil if config is not changed
+	Nodes       func(nodes []node) ([]node, error)   // Nil if nodes.yml is not changed
+}
+
+var migrations = []migration{
+	{1, "add ConfigVersion, add settings introduced before versioning with their defaults", migrateConfigV1, nil},
+}
+
+// configV1Defaults are defaults of settings which older config files may miss, values are pinned,
+// so later changes of cli/swarmgo-config.yml don't affect migrated clusters
+var configV1Defaults = []struct{ key, value string }{
+	{"ClusterNodeNamePrefix", "node"},
+	{"Alertmanager", "prom/alertmanager:v0.15.3"},
+	{"NodeExporter", "stefanprodan/swarmprom-node-exporter:v0.16.0"},
+	{"Grafana", "grafana/grafana:6.4.2"},
+	{"Prometheus", "prom/prometheus:v2.5.0"},
+	{"Traefik", "traefik:v2.1.0-rc2"},
+	{"Cadvisor", "google/cadvisor:v0.31.0"},
+	{"Consul", "consul:1.4.2"},
+	{"Elasticsearch", "docker.elastic.co/elasticsearch/elasticsearch-oss:6.5.4"},
+	{"Filebeat", "kindratte/filebeat:6.5.4"},
+	{"Kibana", "docker.elastic.co/kibana/kibana-oss:6.5.4"},
+	{"Logstash", "docker.elastic.co/logstash/logstash-oss:6.5.4"},
+	{"Curator", "kindratte/curator:5.4"},
+	{"Socat", "alpine/socat:1.7.3.3-r1"},
+	{"GrafanaUser", "admin"},
+	{"PrometheusUser", "admin"},
+	{"TraefikUser", "admin"},
+	{"AlertmanagerUser", "admin"},
+	{"ChannelName", "cluster"},
+}
+
+func migrateConfigV1(content []byte) ([]byte, error) {
+	lines := topLevelKeyLines(content)
+	added := make([]string, 0)
+	for _, d := range configV1Defaults {
+		if _, ok := lines[d.key]; !ok {
+			added = append(added, d.key+": "+d.value)
+		}
+	}
+	if len(added) == 0 {
+		return content, nil
+	}
+	res := strings.TrimRight(string(content), "\n") + "\n\n# Added by migration to version 1\n" + strings.Join(added, "\n") + "\n"
+	return []byte(res), nil
+}
+
+// configVersion returns ConfigVersion of swarmgo-config.yml content
+func configVersion(content []byte) (int, error) {
+	var v struct {
+		ConfigVersion int `yaml:"ConfigVersion"`
+	}
+	if err := yaml.Unmarshal(content, &v); err != nil {
+		return 0, err
+	}
+	if v.ConfigVersion > currentConfigVersion {
+		return 0, fmt.Errorf("%s has ConfigVersion %d, this swarmgo supports up to %d, upgrade swarmgo", swarmgoConfigFileName, v.ConfigVersion, currentConfigVersion)
+	}
+	return v.ConfigVersion, nil
+}
+
+// setConfigVersion replaces ConfigVersion line or adds it to the beginning
+func setConfigVersion(content []byte, version int) []byte {
+	line := fmt.Sprintf("ConfigVersion: %d", version)
+	if configVersionRegexp.Match(content) {
+		return configVersionRegexp.ReplaceAll(content, []byte(line))
+	}
+	return []byte("# Format version of this file, run `swarmgo config migrate` to upgrade files after swarmgo is updated\n" + line + "\n\n" + string(content))
+}
+
+// migrateClusterFiles returns upgraded content of swarmgo-config.yml and nodes.yml and migrations applied
+func migrateClusterFiles(configContent, nodesContent []byte) ([]byte, []byte, []migration, error) {
+	version, err := configVersion(configContent)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	nodes := make([]node, 0)
+	if err := yaml.Unmarshal(nodesContent, &nodes); err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to read %s: %v", nodesFileName, err)
+	}
+	applied := make([]migration, 0)
+	nodesChanged := false
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+		if m.Config != nil {
+			if configContent, err = m.Config(configContent); err != nil {
+				return nil, nil, nil, fmt.Errorf("migration to version %d: %v", m.Version, err)
+			}
+		}
+		if m.Nodes != nil {
+			migrated, err := m.Nodes(nodes)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("migration to version %d: %s: %v", m.Version, nodesFileName, err)
+			}
+			nodesChanged = nodesChanged || !reflect.DeepEqual(migrated, nodes)
+			nodes = migrated
+		}
+		configContent = setConfigVersion(configContent, m.Version)
+		applied = append(applied, m)
+	}
+	if nodesChanged {
+		if nodesContent, err = yaml.Marshal(&nodes); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return configContent, nodesContent, applied, nil
+}
+
+// lineDiff returns changed lines of b against a prefixed by - and +, unchanged lines are skipped
+func lineDiff(a, b string) []string {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+	// lcs[i][j] is the length of common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	res := make([]string, 0)
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			i++
+			j++
+		case j < len(y) && (i == len(x) || lcs[i][j+1] >= lcs[i+1][j]):
+			res = append(res, fmt.Sprintf("+%d: %s", j+1, y[j]))
+			j++
+		default:
+			res = append(res, fmt.Sprintf("-%d: %s", i+1, x[i]))
+			i++
+		}
+	}
+	return res
+}
+
+// backupFile copies file next to it as <file>.v<version>-<time>.bak
+func backupFile(file string, content []byte, version int) (string, error) {
+	backup := fmt.Sprintf("%s.v%d-%s.bak", file, version, time.Now().Format("20060102-150405"))
+	return backup, ioutil.WriteFile(backup, content, 0600)
+}
+
+// migrateClusterDir upgrades swarmgo-config.yml and nodes.yml of the working dir, original files are backed up.
+// Changes are only shown if dryRun is true. Returns false if files are up to date
+func migrateClusterDir(dryRun bool) bool {
+	configFile := filepath.Join(getWorkingDir(), swarmgoConfigFileName)
+	nodesFile := filepath.Join(getWorkingDir(), nodesFileName)
+	configContent, err := ioutil.ReadFile(configFile)
+	gc.ExitIfError(err)
+	nodesContent, err := ioutil.ReadFile(nodesFile)
+	if err != nil && !os.IsNotExist(err) {
+		gc.ExitIfError(err)
+	}
+	version, err := configVersion(configContent)
+	gc.ExitIfError(err)
+	newConfig, newNodes, applied, err := migrateClusterFiles(configContent, nodesContent)
+	gc.ExitIfError(err, "Unable to migrate "+getWorkingDir())
+	if len(applied) == 0 {
+		return false
+	}
+	if dryRun {
+		for _, m := range applied {
+			fmt.Printf("Version %d: %s\n", m.Version, m.Description)
+		}
+		for _, f := range []struct {
+			name     string
+			old, new []byte
+		}{{configFile, configContent, newConfig}, {nodesFile, nodesContent, newNodes}} {
+			if changes := lineDiff(string(f.old), string(f.new)); len(changes) > 0 {
+				fmt.Printf("\n--- %s\n%s\n", f.name, strings.Join(changes, "\n"))
+			}
+		}
+		return true
+	}
+	gc.Info(fmt.Sprintf("Migrating %s from version %d to %d", getWorkingDir(), version, applied[len(applied)-1].Version))
+	for _, f := range []struct {
+		name     string
+		old, new []byte
+		perm     os.FileMode
+	}{{configFile, configContent, newConfig, swarmgoConfigPerms}, {nodesFile, nodesContent, newNodes, 0600}} {
+		if string(f.old) == string(f.new) {
+			continue
+		}
+		backup, err := backupFile(f.name, f.old, version)
+		gc.ExitIfError(err, "Unable to back up "+f.name)
+		gc.ExitIfError(ioutil.WriteFile(f.name, f.new, f.perm))
+		gc.Info(filepath.Base(f.name) + " upgraded, original is kept in " + backup)
+	}
+	return true
+}
+
+// warnIfMigrationRequired reports outdated swarmgo-config.yml, commands which only read the config don't change files
+func warnIfMigrationRequired(content []byte) {
+	// Wrong and newer versions are reported by validation
+	if version, err := configVersion(content); err == nil && version < currentConfigVersion {
+		gc.Info(fmt.Sprintf("%s has ConfigVersion %d, current is %d, run `swarmgo config migrate` to upgrade it", swarmgoConfigFileName, version, currentConfigVersion))
+	}
+}
+
+var configMigrateCmd = &cobra.Command{
+	Use:   "migrate [--dry-run]",
+	Short: "Upgrades swarmgo-config.yml and nodes.yml to the current format, commands which write swarmgo-config.yml also do it before start",
+	Long:  `Original files are kept next to upgraded ones as <file>.v<version>-<time>.bak. Use --dry-run to show changes only`,
+	Run: func(cmd *cobra.Command, args []string) {
+		readWorkingFileIfExists(swarmgoConfigFileName, "You should create swarmgo-config.yml")
+		if !migrateClusterDir(migrateDryRunArg) {
+			gc.Info(fmt.Sprintf("%s is up to date, version %d", getWorkingDir(), currentConfigVersion))
+		}
+	},
+}
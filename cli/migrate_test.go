@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestMigrateClusterFiles(t *testing.T) {
+	config := `# Organization Parameters
+Organization: test
+Cluster: test
+RootUser: root
+ClusterUser: cluster
+ClusterNodeNamePrefix: node
+Traefik: traefik:v1.7.16
+`
+	// nodes.yml as written by swarmgo before versioning
+	nodes := `- host: 10.0.0.1
+  alias: node1
+  dockerversion: 19.03.2
+  swarmmode: leader
+  uname: Linux node1 4.15.0-72-generic #81-Ubuntu SMP x86_64 GNU/Linux
+  traefik: true
+- host: 10.0.0.2
+  alias: node2
+  dockerversion: 19.03.2
+  swarmmode: manager
+  uname: Linux node2 4.15.0-72-generic #81-Ubuntu SMP x86_64 GNU/Linux
+  traefik: false
+`
+	newConfig, newNodes, applied, err := migrateClusterFiles([]byte(config), []byte(nodes))
+	if err != nil || len(applied) != 1 || applied[0].Version != 1 {
+		t.Fatal("Unexpected migrations:", applied, err)
+	}
+	for _, expected := range []string{"ConfigVersion: 1\n", "# Organization Parameters\n", "Traefik: traefik:v1.7.16\n", "Socat: alpine/socat:1.7.3.3-r1\n"} {
+		if !strings.Contains(string(newConfig), expected) {
+			t.Errorf("Migrated config must contain %q:\n%s", expected, newConfig)
+		}
+	}
+	if strings.Count(string(newConfig), "Traefik:") != 1 || strings.Count(string(newConfig), "ClusterNodeNamePrefix:") != 1 {
+		t.Errorf("Existing settings must be kept:\n%s", newConfig)
+	}
+	if problems := validateClusterConfig(newConfig, nil); len(problems) != 0 {
+		t.Error("Migrated config must be valid:", problems)
+	}
+	if string(newNodes) != nodes {
+		t.Errorf("nodes.yml must be kept as is:\n%s", newNodes)
+	}
+	migratedNodes := make([]node, 0)
+	if err := yaml.Unmarshal(newNodes, &migratedNodes); err != nil {
+		t.Fatal(err)
+	}
+	if len(migratedNodes) != 2 || migratedNodes[0].SwarmMode != leader || !migratedNodes[0].Traefik || migratedNodes[1].DockerVersion != "19.03.2" {
+		t.Errorf("Unexpected nodes: %+v", migratedNodes)
+	}
+
+	_, sameNodes, applied, err := migrateClusterFiles(newConfig, newNodes)
+	if err != nil || len(applied) != 0 || string(sameNodes) != string(newNodes) {
+		t.Error("Migrated files must be up to date:", applied, err)
+	}
+	if _, _, _, err := migrateClusterFiles([]byte("ConfigVersion: 99\n"), nil); err == nil {
+		t.Error("Newer version must be reported")
+	}
+
+	changes := lineDiff("a\nb\nc", "x\na\nc\nd")
+	if strings.Join(changes, ",") != "+1: x,-2: b,+4: d" {
+		t.Error("Unexpected diff:", changes)
+	}
+}
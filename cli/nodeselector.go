@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	gc "github.com/untillpro/gochips"
+)
+
+// selectorHelp describes node selector grammar for command descriptions
+const selectorHelp = `Nodes are selected by comma separated terms, node matches if any term matches:
+  node1, node[12], db-*   alias or alias glob
+  all                     all nodes from nodes.yml
+  mode=<leader|manager|worker|none>  swarm mode kept in nodes.yml, none for nodes which are not in swarm yet
+  role=<leader|manager|worker>       live swarm role from docker node ls, manager includes the leader
+  label:<key>[=<value>]   live swarm node label`
+
+// swarmNode is a node as it is seen by the swarm leader
+type swarmNode struct {
+	Role         string // manager or worker
+	Leader       bool
+	Availability string // active, pause or drain
+	State        string // ready or down
+	Labels       map[string]string
+}
+
+// swarmNodesFunc returns swarm nodes by alias, called only if selector needs live data
+type swarmNodesFunc func() (map[string]swarmNode, error)
+
+// selectNodes returns nodes matched by selector in order of terms, nodes matched by the same term are kept in nodes.yml order
+func selectNodes(nodes []node, selector string, live swarmNodesFunc) ([]node, error) {
+	selected := make(map[string]bool)
+	res := make([]node, 0, len(nodes))
+	var swarmNodes map[string]swarmNode
+	liveNodes := func() (map[string]swarmNode, error) {
+		if swarmNodes != nil {
+			return swarmNodes, nil
+		}
+		var err error
+		if swarmNodes, err = live(); err != nil {
+			return nil, fmt.Errorf("unable to read swarm nodes: %v", err)
+		}
+		return swarmNodes, nil
+	}
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		var match func(n node) (bool, error)
+		switch {
+		case len(term) == 0:
+			return nil, fmt.Errorf("empty term in node selector %q", selector)
+		case term == "all":
+			match = func(n node) (bool, error) { return true, nil }
+		case strings.HasPrefix(term, "mode="):
+			mode := strings.TrimPrefix(term, "mode=")
+			if mode != leader && mode != manager && mode != worker && mode != "none" {
+				return nil, fmt.Errorf("unknown mode %s, must be one of: %s, %s, %s, none", mode, leader, manager, worker)
+			}
+			match = func(n node) (bool, error) {
+				return n.SwarmMode == mode || mode == "none" && len(n.SwarmMode) == 0, nil
+			}
+		case strings.HasPrefix(term, "role="):
+			role := strings.TrimPrefix(term, "role=")
+			if role != leader && role != manager && role != worker {
+				return nil, fmt.Errorf("unknown role %s, must be one of: %s, %s, %s", role, leader, manager, worker)
+			}
+			match = func(n node) (bool, error) {
+				swarmNodes, err := liveNodes()
+				s, ok := swarmNodes[n.Alias]
+				return ok && (s.Role == role || role == leader && s.Leader), err
+			}
+		case strings.HasPrefix(term, "label:"), strings.HasPrefix(term, "label="):
+			key, value, hasValue := strings.Cut(term[len("label:"):], "=")
+			match = func(n node) (bool, error) {
+				swarmNodes, err := liveNodes()
+				v, ok := swarmNodes[n.Alias].Labels[key]
+				return ok && (!hasValue || v == value), err
+			}
+		default:
+			if _, err := path.Match(term, ""); err != nil {
+				return nil, fmt.Errorf("wrong alias pattern %s: %v", term, err)
+			}
+			match = func(n node) (bool, error) {
+				matched, _ := path.Match(term, n.Alias)
+				return matched, nil
+			}
+		}
+		found := false
+		for _, n := range nodes {
+			matched, err := match(n)
+			if err != nil {
+				return nil, err
+			}
+			if matched && !selected[n.Alias] {
+				selected[n.Alias] = true
+				res = append(res, n)
+			}
+			found = found || matched
+		}
+		if !found && !strings.ContainsAny(term, "=:") {
+			return nil, fmt.Errorf("no nodes match %s in %s", term, nodesFileName)
+		}
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no nodes match %q", selector)
+	}
+	return res, nil
+}
+
+// liveSwarmNodes reads swarm nodes from the leader, swarm node names are node aliases
+func liveSwarmNodes(client Executor, leaderHost string) (map[string]swarmNode, error) {
+	out, err := client.Exec(leaderHost, "sudo docker node ls -q | xargs sudo docker node inspect")
+	if err != nil {
+		return nil, err
+	}
+	return parseSwarmNodes(out)
+}
+
+func parseSwarmNodes(inspectOutput string) (map[string]swarmNode, error) {
+	var inspected []struct {
+		Spec struct {
+			Role         string
+			Availability string
+			Labels       map[string]string
+		}
+		Description struct {
+			Hostname string
+		}
+		Status struct {
+			State string
+		}
+		ManagerStatus *struct {
+			Leader bool
+		}
+	}
+	if err := json.Unmarshal([]byte(inspectOutput), &inspected); err != nil {
+		return nil, fmt.Errorf("unexpected docker node inspect output: %v", err)
+	}
+	res := make(map[string]swarmNode)
+	for _, i := range inspected {
+		res[i.Description.Hostname] = swarmNode{
+			Role:         i.Spec.Role,
+			Leader:       i.ManagerStatus != nil && i.ManagerStatus.Leader,
+			Availability: i.Spec.Availability,
+			State:        i.Status.State,
+			Labels:       i.Spec.Labels,
+		}
+	}
+	return res, nil
+}
+
+// getNodesBySelector returns nodes from nodes.yml matched by selector terms, live data is read from the leader if needed
+func getNodesBySelector(client Executor, selector ...string) []node {
+	nodes := getNodesFromYml(getWorkingDir())
+	gc.ExitIfFalse(len(nodes) > 0, "Can't find nodes from nodes.yml. Add some nodes first!")
+	selected, err := selectNodes(nodes, strings.Join(selector, ","), func() (map[string]swarmNode, error) {
+		for _, n := range nodes {
+			if n.SwarmMode == leader {
+				return liveSwarmNodes(client, n.Host)
+			}
+		}
+		return nil, errors.New("swarm leader not found in " + nodesFileName)
+	})
+	gc.ExitIfError(err)
+	return selected
+}
+
+func nodeAliases(nodes []node) []string {
+	res := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		res = append(res, n.Alias)
+	}
+	return res
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSelectNodes(t *testing.T) {
+	nodes := []node{
+		{Alias: "node1", Host: "10.0.0.1", SwarmMode: leader},
+		{Alias: "node2", Host: "10.0.0.2", SwarmMode: manager},
+		{Alias: "node3", Host: "10.0.0.3", SwarmMode: worker},
+		{Alias: "db1", Host: "10.0.0.4"},
+	}
+	liveRead := 0
+	live := func() (map[string]swarmNode, error) {
+		liveRead++
+		// node2 is promoted to the leader, node3 is demoted by docker commands after nodes.yml is written
+		return parseSwarmNodes(`[
+{"Spec": {"Role": "manager", "Availability": "active", "Labels": {"prometheus": "true"}}, "Description": {"Hostname": "node1"}, "ManagerStatus": {"Leader": false}},
+{"Spec": {"Role": "manager", "Availability": "drain", "Labels": {}}, "Description": {"Hostname": "node2"}, "ManagerStatus": {"Leader": true}},
+{"Spec": {"Role": "worker", "Labels": {"prometheus": "false", "traefik": ""}}, "Description": {"Hostname": "node3"}}
+]`)
+	}
+	aliases := func(nodes []node) string {
+		return strings.Join(nodeAliases(nodes), ",")
+	}
+
+	for selector, expected := range map[string]string{
+		"all":                               "node1,node2,node3,db1",
+		"node3, node1":                      "node3,node1",
+		"node1,node*":                       "node1,node2,node3",
+		"node*":                             "node1,node2,node3",
+		"node[12],db?":                      "node1,node2,db1",
+		"mode=leader":                       "node1",
+		"mode=none":                         "db1",
+		"mode=worker,node2":                 "node3,node2",
+		"role=manager":                      "node1,node2",
+		"role=leader":                       "node2",
+		"role=worker":                       "node3",
+		"label:prometheus":                  "node1,node3",
+		"label:prometheus=true":             "node1",
+		"label=prometheus=true":             "node1",
+		"label:traefik,label:prometheus=no": "node3",
+	} {
+		selected, err := selectNodes(nodes, selector, live)
+		if err != nil || aliases(selected) != expected {
+			t.Error(selector, "expected", expected, "got", aliases(selected), err)
+		}
+	}
+	if liveRead != 7 {
+		t.Error("Live data must be read once per selector which needs it, read", liveRead)
+	}
+
+	for _, selector := range []string{"node4", "web*", "node[", "role=boss", "mode=drain", "node1,", "label:missing"} {
+		if _, err := selectNodes(nodes, selector, live); err == nil {
+			t.Error("Selector must fail:", selector)
+		}
+	}
+	if _, err := selectNodes(nodes, "role=worker", func() (map[string]swarmNode, error) {
+		return nil, errors.New("no leader")
+	}); err == nil {
+		t.Error("Live data error must be returned")
+	}
+	if selected, err := selectNodes(nodes, "mode=none", nil); err != nil || aliases(selected) != "db1" {
+		t.Error("Live data must not be read for nodes.yml terms", err)
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// retryPolicy describes how failed SSH command is retried
+type retryPolicy struct {
+	maxAttempts int
+	backoff     time.Duration    // delay before the second attempt, doubled for each next one
+	maxBackoff  time.Duration    // upper limit for the delay
+	transport   []*regexp.Regexp // failure to run the command is retried only if the error matches one of patterns
+	remote      []*regexp.Regexp // non-zero exit is retried only if output of the command matches one of patterns
+}
+
+// transportError is returned when the command could not be run or its result was not received,
+// unlike non-zero exit its message comes from SSH, not from the remote command
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+// connectionErrorPatterns match transport failures to connect or authenticate, the step has not started yet, so any step can be retried
+var connectionErrorPatterns = []string{
+	"connection refused",
+	"no route to host",
+	"kex_exchange_identification",
+	"handshake failed: EOF",
+}
+
+// droppedConnectionPatterns match transport failures when connection is lost while the step may be running, only idempotent steps can be retried
+var droppedConnectionPatterns = []string{
+	"connection reset by peer",
+	"connection closed by",
+	"broken pipe",
+}
+
+// aptErrorPatterns match output of apt failed due to locks and transient network errors, e.g. "Failed to fetch ... 404 Not Found" is not retried
+var aptErrorPatterns = []string{
+	"could not get lock",
+	"unable to acquire the dpkg frontend lock",
+	"unable to lock directory",
+	"is another process using it",
+	"temporary failure resolving",
+	"could not resolve",
+	"could not connect to",
+	"unable to connect to",
+	"connection timed out",
+	"connection failed",
+	"50[234] +(service unavailable|bad gateway|gateway time-?out)",
+	"hash sum mismatch",
+}
+
+// defaultRetryPolicy is used for steps which don't specify policy, covers node being unreachable for a while, e.g. sshd restart.
+// Steps like `docker swarm join` may be not idempotent, so they are not retried once started
+var defaultRetryPolicy = newRetryPolicy(5, 5*time.Second, time.Minute, connectionErrorPatterns)
+
+// aptRetryPolicy waits for apt locks held by unattended upgrades and survives DNS hiccups. Apt steps are idempotent,
+// so they are retried if connection is lost as well
+var aptRetryPolicy = newRetryPolicy(10, 10*time.Second, time.Minute, append(append([]string{}, connectionErrorPatterns...), droppedConnectionPatterns...), aptErrorPatterns...)
+
+func newRetryPolicy(maxAttempts int, backoff, maxBackoff time.Duration, transportPatterns []string, remotePatterns ...string) *retryPolicy {
+	return &retryPolicy{
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		maxBackoff:  maxBackoff,
+		transport:   compilePatterns(transportPatterns),
+		remote:      compilePatterns(remotePatterns),
+	}
+}
+
+func compilePatterns(patterns []string) []*regexp.Regexp {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		res = append(res, regexp.MustCompile("(?i)"+pattern))
+	}
+	return res
+}
+
+// isRetryable checks transport errors against transport patterns only, so output of the remote command
+// which mentions e.g. "connection refused" does not make started step be retried
+func (p *retryPolicy) isRetryable(err error) bool {
+	patterns := p.remote
+	var transportErr *transportError
+	if errors.As(err, &transportErr) {
+		patterns = p.transport
+	}
+	for _, re := range patterns {
+		if re.MatchString(err.Error()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *retryPolicy) delay(attempt int) time.Duration {
+	d := p.backoff
+	for i := 1; i < attempt && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	return d
+}
+
+// do calls f until it succeeds, fails with not retryable error, attempts are exhausted or command is cancelled
+func (p *retryPolicy) do(host string, f func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= p.maxAttempts || !p.isRetryable(err) {
+			return err
+		}
+		delay := p.delay(attempt)
+		logWithPrefix(host, fmt.Sprintf("Attempt %d of %d failed, retrying in %v: %v", attempt, p.maxAttempts, delay, err))
+		select {
+		case <-commandContext().Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
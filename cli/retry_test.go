@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	lockErr := errors.New("exit status 100 / E: Could not get lock /var/lib/dpkg/lock-frontend - open (11: Resource temporarily unavailable)")
+	refusedErr := &transportError{errors.New("dial tcp 10.0.0.1:22: connect: connection refused")}
+	packageErr := errors.New("exit status 100 / E: Unable to locate package docker-ce")
+	droppedErr := &transportError{errors.New("wait: remote command exited without exit status or exit signal / Connection closed by 10.0.0.1 port 22")}
+	notFoundErr := errors.New("exit status 100 / E: Failed to fetch http://archive.ubuntu.com/ubuntu/pool/main/c/curl/curl_7.68.0-1ubuntu2.7_amd64.deb  404  Not Found [IP: 91.189.88.142 80]")
+	resolveErr := errors.New("exit status 100 / E: Failed to fetch http://archive.ubuntu.com/ubuntu/dists/focal/InRelease  Temporary failure resolving 'archive.ubuntu.com'")
+
+	if !aptRetryPolicy.isRetryable(lockErr) || !aptRetryPolicy.isRetryable(refusedErr) {
+		t.Error("apt lock and connection refused must be retried by apt policy")
+	}
+	if aptRetryPolicy.isRetryable(packageErr) || aptRetryPolicy.isRetryable(notFoundErr) {
+		t.Error("Missing package must not be retried")
+	}
+	unavailableErr := errors.New("exit status 100 / E: Failed to fetch https://download.docker.com/linux/ubuntu/dists/focal/InRelease  503  Service Unavailable")
+	if !aptRetryPolicy.isRetryable(resolveErr) || !aptRetryPolicy.isRetryable(droppedErr) || !aptRetryPolicy.isRetryable(unavailableErr) {
+		t.Error("Transient network errors and dropped connection must be retried by apt policy")
+	}
+	if defaultRetryPolicy.isRetryable(lockErr) || !defaultRetryPolicy.isRetryable(refusedErr) {
+		t.Error("Default policy must retry connection errors only")
+	}
+	if defaultRetryPolicy.isRetryable(droppedErr) {
+		t.Error("Step which may be running must not be retried by default policy")
+	}
+	remoteRefusedErr := errors.New("exit status 7 / curl: (7) Failed to connect to 10.0.0.5 port 2377: Connection refused")
+	if defaultRetryPolicy.isRetryable(remoteRefusedErr) || aptRetryPolicy.isRetryable(errors.New("exit status 1 / Connection closed by 10.0.0.5")) {
+		t.Error("Output of started command must not be taken as transport error")
+	}
+
+	p := newRetryPolicy(3, time.Millisecond, 2*time.Millisecond, []string{"connection refused"})
+	if p.delay(1) != time.Millisecond || p.delay(2) != 2*time.Millisecond || p.delay(5) != 2*time.Millisecond {
+		t.Error("Unexpected backoff:", p.delay(1), p.delay(2), p.delay(5))
+	}
+
+	calls := 0
+	err := p.do("node1", func() error {
+		calls++
+		return refusedErr
+	})
+	if err != error(refusedErr) || calls != 3 {
+		t.Error("Expected 3 attempts, got:", calls, err)
+	}
+
+	calls = 0
+	err = p.do("node1", func() error {
+		calls++
+		if calls == 1 {
+			return refusedErr
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Error("Expected success on second attempt, got:", calls, err)
+	}
+
+	calls = 0
+	err = p.do("node1", func() error {
+		calls++
+		return packageErr
+	})
+	if err != packageErr || calls != 1 {
+		t.Error("Not retryable error must not be retried, got:", calls, err)
+	}
+}
+
+func TestExecStepRetriesTransportErrorsOnly(t *testing.T) {
+	policy := newRetryPolicy(3, time.Millisecond, time.Millisecond, connectionErrorPatterns)
+	client := newFakeExecutor(fixtureEntry{Command: "docker swarm join", ExitCode: 1, Stderr: "dial tcp 10.0.0.1:2377: connect: connection refused"})
+	err := execStep("10.0.0.2", client, SSHCommand{cmd: "docker swarm join", retry: policy})
+	if err == nil || len(client.Executed) != 1 {
+		t.Error("Started step must not be retried because of its output, executed:", len(client.Executed), err)
+	}
+}
@@ -11,18 +11,23 @@ package cli
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
 
 type clusterFile struct {
+	ConfigVersion         int                          `yaml:"ConfigVersion"`
 	OrganizationName      string                       `yaml:"Organization"`
 	ClusterName           string                       `yaml:"Cluster"`
 	RootUserName          string                       `yaml:"RootUser"`
 	ClusterUserName       string                       `yaml:"ClusterUser"`
 	ClusterNodeNamePrefix string                       `yaml:"ClusterNodeNamePrefix"`
+	SudoWithPassword      bool                         `yaml:"SudoWithPassword"`
 	PublicKey             string                       `yaml:"PublicKey"`
 	PrivateKey            string                       `yaml:"PrivateKey"`
+	KeyType               string                       `yaml:"KeyType,omitempty"`
+	KeyFingerprint        string                       `yaml:"KeyFingerprint,omitempty"`
 	Docker                map[string]map[string]string `yaml:"Docker"`
 	Alertmanager          string                       `yaml:"Alertmanager"`
 	NodeExporter          string                       `yaml:"NodeExporter"`
@@ -46,6 +51,8 @@ type clusterFile struct {
 	Logstash              string                       `yaml:"Logstash"`
 	Curator               string                       `yaml:"Curator"`
 	EncryptSwarmNetworks  bool                         `yaml:"EncryptSwarmNetworks"`
+	Bastion               []bastionHost                `yaml:"Bastion,omitempty"`
+	Users                 []operator                   `yaml:"Users,omitempty"`
 	WebhookURL            string
 	GrafanaPassword       string
 	PrometheusBasicAuth   string
@@ -66,14 +73,41 @@ var rootCmd = &cobra.Command{
 func Execute() {
 
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output")
+	rootCmd.PersistentFlags().DurationVar(&argTimeout, "timeout", 0, "Timeout for every remote command, e.g. 30s or 5m (default depends on command)")
+	rootCmd.PersistentFlags().StringVar(&clusterArg, "cluster", "", "Cluster context to use, overrides "+clusterEnvVar+" environment variable and current context")
+	rootCmd.PersistentFlags().StringArrayVar(&configSetArgs, "set", nil, "Override swarmgo-config.yml setting as Key=Value, e.g. --set ACMEEnabled=true, may be repeated")
+	rootCmd.PersistentFlags().BoolVar(&useSystemSSH, "system-ssh", false, "Use ssh/scp executables (sshpass on Linux and plink on Windows for passwords) instead of built-in SSH client")
 
 	rootCmd.AddCommand(initCmd)
 
+	rootCmd.AddCommand(contextCmd)
+	contextCmd.AddCommand(contextLsCmd)
+	contextCmd.AddCommand(contextUseCmd)
+	contextCmd.AddCommand(contextCreateCmd)
+
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configMigrateCmd)
+	configMigrateCmd.Flags().BoolVarP(&migrateDryRunArg, "dry-run", "", false, "Show changes without writing files")
+	configShowCmd.Flags().BoolVarP(&configShowOriginArg, "origin", "", false, "Show where every value comes from: file line, environment variable, --set or default")
+
+	rootCmd.AddCommand(credsCmd)
+	credsCmd.AddCommand(credsSetCmd)
+	credsCmd.AddCommand(credsGetCmd)
+	credsCmd.AddCommand(credsGenerateCmd)
+	credsCmd.AddCommand(credsLsCmd)
+	credsGenerateCmd.Flags().IntVarP(&credsGenerateLengthArg, "length", "l", 24, "Length of generated passwords")
+
 	rootCmd.AddCommand(keysCmd)
 	keysCmd.Flags().StringVarP(&privateKeyArg, "private", "p", "", "Private key path")
 	keysCmd.Flags().StringVarP(&publicKeyArg, "public", "u", "", "Public key path")
+	keysCmd.Flags().StringVarP(&keyTypeArg, "type", "t", keyTypeEd25519, "Type of generated keys: "+strings.Join(keyTypes, "|"))
+	keysCmd.AddCommand(keysRotateCmd)
+	keysRotateCmd.Flags().StringVarP(&rotateKeyTypeArg, "type", "t", "", "Type of new keys: "+strings.Join(keyTypes, "|")+", type of current keys by default")
 
 	rootCmd.AddCommand(agentCmd)
+	agentCmd.AddCommand(agentListCmd)
 
 	rootCmd.AddCommand(imluckyCmd)
 	imluckyCmd.Flags().BoolVarP(&luckyNoAlerts, "no-alerts", "n", false, "Configure no push alerts in Prometheus Alertmamnager")
@@ -84,7 +118,10 @@ func Execute() {
 
 	rootCmd.AddCommand(addNodeCmd)
 	addNodeCmd.Flags().BoolVarP(&skipSSHConfiguration, "skip-ssh", "s", false, "Use this option when ClusterUser already exists and SSH access is configured for on nodes being added")
-	addNodeCmd.Flags().StringVarP(&argRootPassword, "password", "p", "", "Specify default password. Requires sshpass on Linux and plink on Windows")
+	addNodeCmd.Flags().StringVarP(&argSSHKey, "key", "k", "", "Private key used to access nodes being added instead of cluster key, public key is expected in <key>.pub")
+	addNodeCmd.Flags().StringVarP(&argRootPassword, "password", "p", "", "Specify default password")
+
+	rootCmd.AddCommand(hostKeysCmd)
 
 	rootCmd.AddCommand(dockerCmd)
 	dockerCmd.Flags().BoolVarP(&forceUpgradeDocker, "upgrade", "u", false, "Upgrade docker to the latest version, if already installed")
@@ -94,6 +131,35 @@ func Execute() {
 	labelCmd.AddCommand(labelAddCmd)
 	labelCmd.AddCommand(labelRmCmd)
 
+	rootCmd.AddCommand(drainCmd)
+	rootCmd.AddCommand(activateCmd)
+
+	rootCmd.AddCommand(logsCmd)
+	logsCmd.AddCommand(logsLastCmd)
+	logsLastCmd.Flags().StringVarP(&logsHostArg, "host", "", "", "Show transcript of given node alias or host only")
+
+	rootCmd.AddCommand(connectConfigCmd)
+	connectConfigCmd.Flags().StringVarP(&connectConfigOutputArg, "output", "o", "", "SSH config file to write, ~/.ssh/swarmgo-<Cluster>.conf by default")
+	connectConfigCmd.Flags().StringVarP(&connectConfigPrefixArg, "prefix", "", "", "Prefix for Host entries, e.g. prod- to access nodes as prod-node1")
+	connectConfigCmd.Flags().BoolVarP(&connectConfigIncludeArg, "include", "i", false, "Add Include of generated file to ~/.ssh/config")
+	connectConfigCmd.Flags().BoolVarP(&connectConfigGrantDockerArg, "grant-docker", "", false, "Add SSH user to docker group on the leader, so docker context works without sudo")
+
+	rootCmd.AddCommand(sshCmd)
+	rootCmd.AddCommand(execCmd)
+	execCmd.Flags().StringVarP(&execNodesArg, "nodes", "", "", "Nodes to run command on, e.g. all, node1,node2, role=worker or label:prometheus=true")
+
+	rootCmd.AddCommand(cpCmd)
+	cpCmd.Flags().StringVarP(&cpModeArg, "mode", "m", "", "Permissions of copied files on nodes, e.g. 0644, permissions of local files by default")
+	cpCmd.Flags().StringVarP(&cpOwnerArg, "owner", "o", "", "Owner of copied files on nodes as user[:group], changed by sudo")
+
+	rootCmd.AddCommand(usersCmd)
+	usersCmd.AddCommand(usersAddCmd)
+	usersCmd.AddCommand(usersRmCmd)
+	usersCmd.AddCommand(usersLsCmd)
+	usersCmd.AddCommand(usersSyncCmd)
+	usersAddCmd.Flags().StringVarP(&userKeyArg, "key", "k", "", "Public key file of the user")
+	usersAddCmd.Flags().BoolVarP(&userSudoArg, "sudo", "", false, "Allow user to run sudo")
+
 	rootCmd.AddCommand(eLKCmd)
 
 	rootCmd.AddCommand(swarmCmd)
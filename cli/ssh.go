@@ -10,38 +10,96 @@ package cli
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
-	"path/filepath"
-	"runtime"
 	"strings"
+	"time"
 
-	"github.com/kballard/go-shellquote"
+	"github.com/pkg/sftp"
 	gc "github.com/untillpro/gochips"
 )
 
-// SSHClient implements a simple SSH client working over standard exec.Command
+// SSHClient implements a simple SSH client, commands are delivered to hosts by pluggable transport
 type SSHClient struct {
 	User                  string
 	PrivateKeyFile        string
 	StrictHostKeyChecking bool
 	Verbose               bool
 	HideStdout            bool
+	Timeout               time.Duration // Default timeout for commands, unlimited if zero
 	Password              string
+	SudoPassword          string // Answers sudo prompts if not empty, nodes with NOPASSWD sudo don't need it
+	IdentitiesOnly        bool   // Authenticate by PrivateKeyFile only, keys loaded to ssh-agent are not offered
 	TempDir               string
+	Nodes                 map[string]node // Nodes from nodes.yml by host
+	Bastion               []bastionHost   // Jump hosts used for nodes without own bastion configured
+	bastionHostKeys       *bastionHostKeyStore
+	nodeHostKeys          *nodeHostKeyStore
+	transport             sshTransport
+	sudoPasswordPrompt    func() string // Asks for SudoPassword when the first command which needs it is run
 }
 
-func checkSSHAgent() {
-	output := os.Getenv("SSH_AUTH_SOCK")
-	gc.Verbose("SSH_AUTH_SOCK", string(output))
-	gc.ExitIfFalse(len(output) > 0, "ssh-agent isn't running")
+// sshTransport delivers commands and files to remote hosts
+type sshTransport interface {
+	// run runs command on target host, stdin is os.Stdin when user input may be expected.
+	// Remote command is stopped when ctx is done
+	run(ctx context.Context, target *sshTarget, command string, stdin io.Reader, stdout, stderr io.Writer) error
+	// copy copies contents to the destination on target host using scp protocol
+	copy(ctx context.Context, target *sshTarget, size int64, mode os.FileMode, fileName string, contents io.Reader, destination string) error
+	// interactive runs command, or login shell if command is empty, with local terminal attached to remote tty
+	interactive(target *sshTarget, command string) error
+	// sftp starts SFTP session on target host, session ends when client is closed
+	sftp(target *sshTarget) (*sftp.Client, error)
 }
 
+// ExecResult is a result of the command executed on remote host
+type ExecResult struct {
+	Host     string
+	Command  string
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	Duration time.Duration
+}
+
+// Output returns stdout without trailing line breaks
+func (r *ExecResult) Output() string {
+	return strings.TrimRight(r.Stdout, "\r\n")
+}
+
+// remoteExitError is returned by transports when remote command exits with non-zero code
+type remoteExitError struct {
+	code int
+	err  error
+}
+
+func (e *remoteExitError) Error() string {
+	return e.err.Error()
+}
+
+// sshTarget keeps everything transport needs to reach the host
+type sshTarget struct {
+	host                  string
+	port                  int
+	user                  string
+	privateKeyFile        string
+	password              string
+	strictHostKeyChecking bool
+	hostKey               string
+	identitiesOnly        bool
+	jumps                 []*sshTarget
+	tempDir               string
+	pinHostKey            func(fingerprint string) error // Persists key accepted on first use, nil if key is not persisted
+}
+
+const defaultSSHPort = 22
+
+var useSystemSSH bool
+
 // Client returns the SSHClient struct
 func Client(user string, privateKey string) *SSHClient {
 	return &SSHClient{
@@ -49,106 +107,177 @@ func Client(user string, privateKey string) *SSHClient {
 		PrivateKeyFile:        privateKey,
 		StrictHostKeyChecking: true,
 		Verbose:               false,
+		transport:             newTransport(),
 	}
 }
 
+func newTransport() sshTransport {
+	if useSystemSSH {
+		return &execTransport{}
+	}
+	return &nativeTransport{}
+}
+
 func (c *SSHClient) prefixed(host, str string) string {
 	return "SSHClient [" + c.User + "@" + host + "]: " + str
 }
 
-func (c *SSHClient) cmd(cmd *exec.Cmd) (string, error) {
-	cmd.Stdin = os.Stdin
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return "", err
+func (c *SSHClient) target(host string) *sshTarget {
+	node := c.Nodes[host]
+	bastion := c.Bastion
+	if len(node.Bastion) > 0 {
+		bastion = node.Bastion
 	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return "", err
+	user, port, privateKeyFile := c.User, defaultSSHPort, c.PrivateKeyFile
+	if len(node.SSHUser) > 0 {
+		user = node.SSHUser
 	}
-
-	var bufErr bytes.Buffer
-	var bufOut bytes.Buffer
-
-	teeOut := io.TeeReader(stdout, &bufOut)
-	teeErr := io.TeeReader(stderr, &bufErr)
-
-	err = cmd.Start()
-	if err != nil {
-		return "Unable to start command", err
+	if node.SSHPort > 0 {
+		port = node.SSHPort
 	}
-
-	go io.Copy(os.Stdout, teeOut)
-	go io.Copy(os.Stderr, teeErr)
-
-	err = cmd.Wait()
-	if err != nil {
-		stdErrStr := bufErr.String()
-		if stdErrStr != "" {
-			err = errors.New(err.Error() + " / " + stdErrStr)
+	if len(node.SSHKey) > 0 {
+		privateKeyFile = node.SSHKey
+	}
+	jumps := bastionTargets(bastion)
+	if c.bastionHostKeys != nil {
+		c.pinBastionHostKeys(jumps)
+	}
+	for _, jump := range jumps {
+		jump.identitiesOnly = c.IdentitiesOnly
+	}
+	// Bastion itself is reached through the bastions preceding it in the chain, by its own user and key
+	for _, jump := range jumps {
+		if jump.host == host && jump.port == port {
+			res := *jump
+			res.strictHostKeyChecking = c.StrictHostKeyChecking
+			res.tempDir = c.TempDir
+			return &res
 		}
-		return stdErrStr, err
 	}
-
-	out := strings.TrimRight(bufOut.String(), "\r\n")
-	return out, nil
+	var pinHostKey func(fingerprint string) error
+	if len(node.HostKey) == 0 && c.nodeHostKeys != nil {
+		pinHostKey = func(fingerprint string) error {
+			return c.nodeHostKeys.pin(host, fingerprint)
+		}
+	}
+	return &sshTarget{
+		host:                  host,
+		port:                  port,
+		user:                  user,
+		privateKeyFile:        privateKeyFile,
+		password:              c.Password,
+		strictHostKeyChecking: c.StrictHostKeyChecking,
+		hostKey:               node.HostKey,
+		identitiesOnly:        c.IdentitiesOnly,
+		jumps:                 jumps,
+		tempDir:               c.TempDir,
+		pinHostKey:            pinHostKey,
+	}
 }
 
-func (c *SSHClient) cmdHidden(cmd *exec.Cmd) (string, error) {
-
-	var bErr bytes.Buffer
-	cmd.Stderr = &bErr
+// pinBastionHostKeys sets keys pinned on first use to bastions without HostKey, keys of bastions never reached are pinned when seen
+func (c *SSHClient) pinBastionHostKeys(jumps []*sshTarget) {
+	for _, jump := range jumps {
+		if len(jump.hostKey) > 0 {
+			continue
+		}
+		jump := jump
+		// Unreadable file is reported by pin
+		jump.hostKey, _ = c.bastionHostKeys.get(jump.host, jump.port)
+		if len(jump.hostKey) == 0 {
+			jump.pinHostKey = func(fingerprint string) error {
+				return c.bastionHostKeys.pin(jump.host, jump.port, fingerprint)
+			}
+		}
+	}
+}
 
-	bs, err := cmd.Output()
+// run runs command, stdin is given input if not nil. Sudo password goes before the input when command is wrapped
+func (c *SSHClient) run(ctx context.Context, host, command string, input io.Reader) (*ExecResult, error) {
+	var bufOut bytes.Buffer
+	var bufErr bytes.Buffer
 
-	if err != nil {
-		stdErrStr := bErr.String()
-		if len(stdErrStr) > 0 {
-			err = errors.New(err.Error() + " / " + stdErrStr)
+	var stdin io.Reader
+	var stdout io.Writer = &bufOut
+	var stderr io.Writer = &bufErr
+	if !c.HideStdout {
+		stdin = os.Stdin
+		stdout = io.MultiWriter(os.Stdout, &bufOut)
+		stderr = io.MultiWriter(os.Stderr, &bufErr)
+	}
+	if input != nil {
+		stdin = input
+	}
+	remoteCommand, wrapped := command, false
+	if len(c.SudoPassword) > 0 || c.sudoPasswordPrompt != nil {
+		remoteCommand, wrapped = withSudoPassword(command)
+	}
+	if wrapped {
+		sudoPassword := c.SudoPassword
+		if len(sudoPassword) == 0 {
+			sudoPassword = c.sudoPasswordPrompt()
+		}
+		stdin = strings.NewReader(sudoPassword + "\n")
+		if input != nil {
+			stdin = io.MultiReader(stdin, input)
 		}
-		return stdErrStr, err
 	}
 
-	out := strings.TrimRight(string(bs), "\r\n")
-	return out, nil
+	start := time.Now()
+	err := c.transport.run(ctx, c.target(host), remoteCommand, stdin, stdout, stderr)
+	res := &ExecResult{
+		Host:     host,
+		Command:  command,
+		Stdout:   bufOut.String(),
+		Stderr:   bufErr.String(),
+		Duration: time.Since(start),
+	}
+	if ctx.Err() != nil {
+		res.ExitCode = -1
+		return res, contextError(ctx, res.Duration)
+	}
+	var exitErr *remoteExitError
+	if errors.As(err, &exitErr) {
+		res.ExitCode = exitErr.code
+		return res, nil
+	}
+	if err != nil {
+		res.ExitCode = -1
+	}
+	return res, err
 }
 
-func (c *SSHClient) loggedCmd(host string, cmd *exec.Cmd, maskInput, maskOutput bool) (string, error) {
+func (c *SSHClient) loggedRun(ctx context.Context, host, command string, input io.Reader, maskInput, maskOutput bool) (*ExecResult, error) {
 
 	if c.Verbose {
-		loggedInput := strings.Join(cmd.Args, " ")
+		loggedInput := command
 		if maskInput {
-			loggedInput = loggedInput[0:4] + "**(masked)**"
+			loggedInput = "**(masked)**"
 		}
 		gc.Verbose(c.prefixed(host, loggedInput))
 	}
 
-	var err error
-	var out string
-
-	if c.HideStdout {
-		out, err = c.cmdHidden(cmd)
-	} else {
-		out, err = c.cmd(cmd)
-	}
+	res, err := c.run(ctx, host, command, input)
+	writeTranscript(host, transcriptEntry(c.User, res, err, maskInput, maskOutput))
 
 	if c.Verbose {
 		if err != nil {
 			gc.Verbose(c.prefixed(host, "failed: "+err.Error()))
+		} else if res.ExitCode != 0 {
+			gc.Verbose(c.prefixed(host, fmt.Sprintf("exit code %d in %v: %s", res.ExitCode, res.Duration, res.Stderr)))
 		} else {
 			if maskOutput {
-				gc.Verbose(c.prefixed(host, "success"), "**(masked)**")
+				gc.Verbose(c.prefixed(host, fmt.Sprintf("success in %v", res.Duration)), "**(masked)**")
 			} else {
-				gc.Verbose(c.prefixed(host, "success"), out)
+				gc.Verbose(c.prefixed(host, fmt.Sprintf("success in %v", res.Duration)), res.Output())
 			}
 		}
 	}
 
-	return out, err
+	return res, err
 }
 
-func (c *SSHClient) isMasked(cmd string) (command string, maskInput, maskOutput bool) {
+func isMasked(cmd string) (command string, maskInput, maskOutput bool) {
 	maskInput = strings.HasPrefix(cmd, "!") || strings.HasPrefix(cmd, "&")
 	maskOutput = strings.HasPrefix(cmd, "$") || strings.HasPrefix(cmd, "&")
 	if maskInput || maskOutput {
@@ -159,52 +288,54 @@ func (c *SSHClient) isMasked(cmd string) (command string, maskInput, maskOutput
 	return command, maskInput, maskOutput
 }
 
+// Run executes the SSH command within client Timeout and returns its result, the same prefixes as for Exec can be used.
+// Error is returned only if command could not be executed, non-zero exit code is not an error
+func (c *SSHClient) Run(host string, command string) (*ExecResult, error) {
+	ctx, cancel := timeoutContext(c.Timeout)
+	defer cancel()
+	return c.RunContext(ctx, host, command)
+}
+
+// RunContext is like Run but command is stopped when ctx is done
+func (c *SSHClient) RunContext(ctx context.Context, host string, command string) (*ExecResult, error) {
+	command, maskInput, maskOutput := isMasked(command)
+	return c.loggedRun(ctx, host, command, nil, maskInput, maskOutput)
+}
+
+// RunInput is like RunContext but input is given to the command on stdin
+func (c *SSHClient) RunInput(ctx context.Context, host string, command string, input io.Reader) (*ExecResult, error) {
+	command, maskInput, maskOutput := isMasked(command)
+	return c.loggedRun(ctx, host, command, input, maskInput, maskOutput)
+}
+
 // Exec executes the SSH command. The following prefixes can be added clarify verbose:
 //    ! - mask verbosed input
 //    $ - mask verbosed output
 //    & - mask verbosed input & output
+// In case of failure stderr is returned instead of stdout
 func (c *SSHClient) Exec(host string, command string) (string, error) {
-	args := make([]string, 0)
-	args = append(args, c.User+"@"+host)
-	if !c.StrictHostKeyChecking {
-		args = append(args, "-o StrictHostKeyChecking=no")
-	}
-	if len(c.PrivateKeyFile) > 0 {
-		args = append(args, "-i")
-		args = append(args, c.PrivateKeyFile)
+	ctx, cancel := timeoutContext(c.Timeout)
+	defer cancel()
+	return c.ExecContext(ctx, host, command)
+}
+
+// ExecContext is like Exec but command is stopped when ctx is done
+func (c *SSHClient) ExecContext(ctx context.Context, host string, command string) (string, error) {
+	return execOutput(c.RunContext(ctx, host, command))
+}
+
+// execOutput converts result of Run to the result of Exec, non-zero exit code is an error
+func execOutput(res *ExecResult, err error) (string, error) {
+	if err == nil && res.ExitCode != 0 {
+		err = fmt.Errorf("exit status %d", res.ExitCode)
 	}
-	command, maskInput, maskOutput := c.isMasked(command)
-	args = append(args, command)
-	var cmd *exec.Cmd
-	if len(c.Password) > 0 {
-		if runtime.GOOS == "windows" {
-
-			tmp := filepath.Join(c.TempDir, fmt.Sprintf("%s@%s-cmd", c.User, host))
-			ioutil.WriteFile(tmp, []byte(command), os.ModePerm) // write command(s) to file
-
-			argsPas := make([]string, 0)
-			argsPas = append(argsPas, "-batch")
-			argsPas = append(argsPas, "-no-antispoof") // TODO: check this option
-			argsPas = append(argsPas, "-ssh")
-			argsPas = append(argsPas, "-pw")
-			argsPas = append(argsPas, c.Password)
-			argsPas = append(argsPas, "-m")
-			argsPas = append(argsPas, tmp)
-			argsPas = append(argsPas, fmt.Sprintf("%s@%s", c.User, host))
-
-			cmd = exec.Command("plink", argsPas[:]...)
-		} else {
-			argsPas := make([]string, 0)
-			argsPas = append(argsPas, "-p"+c.Password)
-			argsPas = append(argsPas, "ssh")
-			argsPas = append(argsPas, args...)
-			cmd = exec.Command("sshpass", argsPas[:]...)
+	if err != nil {
+		if len(res.Stderr) > 0 {
+			err = errors.New(err.Error() + " / " + res.Stderr)
 		}
-	} else {
-		cmd = exec.Command("ssh", args[:]...)
+		return res.Stderr, err
 	}
-
-	return c.loggedCmd(host, cmd, maskInput, maskOutput)
+	return res.Output(), nil
 }
 
 // ExecOrExit executes SSH command and terminates program execution with status (1) in case of any error
@@ -216,7 +347,42 @@ func (c *SSHClient) ExecOrExit(host string, command string) string {
 
 // Copy copies local file to host by SSH
 func (c *SSHClient) Copy(host string, size int64, mode os.FileMode, fileName string, contents io.Reader, destinationPath string) error {
-	return c.copy(host, size, mode, fileName, contents, destinationPath)
+	ctx, cancel := timeoutContext(c.Timeout)
+	defer cancel()
+	start := time.Now()
+	err := c.transport.copy(ctx, c.target(host), size, mode, fileName, contents, destinationPath)
+	if ctx.Err() != nil {
+		err = contextError(ctx, time.Since(start))
+	}
+	writeTranscript(host, transcriptNote(c.User, fmt.Sprintf("copy %d bytes with mode %#o to %s", size, mode, destinationPath), err))
+	return err
+}
+
+// Fetch s.e.
+func (c *SSHClient) Fetch(host string, command string, contents io.Writer) error {
+	ctx, cancel := timeoutContext(c.Timeout)
+	defer cancel()
+	if c.Verbose {
+		gc.Verbose(c.prefixed(host, "Fetching output of ["+command+"]"))
+	}
+	var stderr bytes.Buffer
+	start := time.Now()
+	err := c.transport.run(ctx, c.target(host), command, nil, contents, &stderr)
+	var exitErr *remoteExitError
+	if ctx.Err() != nil {
+		err = contextError(ctx, time.Since(start))
+	} else if errors.As(err, &exitErr) {
+		err = fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	writeTranscript(host, transcriptNote(c.User, "fetch output of "+command, err))
+	return err
+}
+
+// Interactive opens interactive session on the host, remote exit code is returned as *remoteExitError
+func (c *SSHClient) Interactive(host string, command string) error {
+	gc.Verbose(c.prefixed(host, "Interactive session"), command)
+	writeTranscript(host, transcriptNote(c.User, "interactive session "+command, nil))
+	return c.transport.interactive(c.target(host), command)
 }
 
 // CopyPath copies local path to host by SSH
@@ -233,46 +399,5 @@ func (c *SSHClient) CopyPath(host string, filePath, destinationPath string) erro
 	if c.Verbose {
 		gc.Verbose(c.prefixed(host, fmt.Sprintf("Copying %d bytes from [%s] to [%s]", s.Size(), filePath, destinationPath)))
 	}
-	return c.copy(host, s.Size(), s.Mode().Perm(), path.Base(filePath), f, destinationPath)
-}
-
-func (c *SSHClient) copy(host string, size int64, mode os.FileMode, fileName string, contents io.Reader, destination string) error {
-	command := shellquote.Join("scp", "-t", destination)
-
-	args := make([]string, 0)
-	args = append(args, c.User+"@"+host)
-	if !c.StrictHostKeyChecking {
-		args = append(args, "-o StrictHostKeyChecking=no")
-	}
-	if len(c.PrivateKeyFile) > 0 {
-		args = append(args, "-i")
-		args = append(args, c.PrivateKeyFile)
-	}
-	args = append(args, command)
-	cmd := exec.Command("ssh", args[:]...)
-
-	//	defer session.Close()
-	w, err := cmd.StdinPipe()
-
-	if err != nil {
-		return err
-	}
-
-	if err := cmd.Start(); err != nil {
-		w.Close()
-		return err
-	}
-
-	errors := make(chan error)
-
-	go func() {
-		errors <- cmd.Wait()
-	}()
-
-	fmt.Fprintf(w, "C%#o %d %s\n", mode, size, fileName)
-	io.Copy(w, contents)
-	fmt.Fprint(w, "\x00")
-	w.Close()
-
-	return <-errors
+	return c.Copy(host, s.Size(), s.Mode().Perm(), path.Base(filePath), f, destinationPath)
 }
@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+type stubTransport struct {
+	stdout, stderr string
+	err            error
+}
+
+func (t *stubTransport) run(ctx context.Context, target *sshTarget, command string, stdin io.Reader, stdout, stderr io.Writer) error {
+	io.WriteString(stdout, t.stdout)
+	io.WriteString(stderr, t.stderr)
+	return t.err
+}
+
+func (t *stubTransport) copy(ctx context.Context, target *sshTarget, size int64, mode os.FileMode, fileName string, contents io.Reader, destination string) error {
+	return t.err
+}
+
+func (t *stubTransport) interactive(target *sshTarget, command string) error {
+	return t.err
+}
+
+func (t *stubTransport) sftp(target *sshTarget) (*sftp.Client, error) {
+	return nil, t.err
+}
+
+func TestSSHClientRun(t *testing.T) {
+	client := Client("cluster", "")
+	client.HideStdout = true
+
+	client.transport = &stubTransport{stdout: "0\n", err: &remoteExitError{1, errors.New("exit status 1")}}
+	res, err := client.Run("10.0.0.1", "grep -c label")
+	if err != nil || res.ExitCode != 1 || res.Output() != "0" || res.Host != "10.0.0.1" {
+		t.Error("Non-zero exit code must be reported in result, got:", res, err)
+	}
+	out, err := client.Exec("10.0.0.1", "grep -c label")
+	if err == nil {
+		t.Error("Exec must fail on non-zero exit code, got:", out)
+	}
+
+	client.transport = &stubTransport{stderr: "connection refused", err: errors.New("dial tcp")}
+	res, err = client.Run("10.0.0.1", "grep -c label")
+	if err == nil || res.ExitCode != -1 {
+		t.Error("Transport failure must be reported as error, got:", res, err)
+	}
+	out, err = client.Exec("10.0.0.1", "grep -c label")
+	if err == nil || out != "connection refused" {
+		t.Error("Exec must return stderr on failure, got:", out, err)
+	}
+
+	client.transport = &stubTransport{stdout: "Docker version 19.03.2\r\n"}
+	out, err = client.Exec("10.0.0.1", "docker -v")
+	if err != nil || out != "Docker version 19.03.2" {
+		t.Error("Unexpected Exec output:", out, err)
+	}
+}
+
+func TestSSHClientTarget(t *testing.T) {
+	client := Client("cluster", "/keys/cluster")
+	client.Nodes = map[string]node{
+		"10.0.0.2": {Host: "10.0.0.2", SSHUser: "ubuntu", SSHPort: 2222, SSHKey: "/keys/ubuntu"},
+	}
+	target := client.target("10.0.0.1")
+	if target.user != "cluster" || target.port != defaultSSHPort || target.privateKeyFile != "/keys/cluster" {
+		t.Error("Cluster settings expected, got:", target)
+	}
+	target = client.target("10.0.0.2")
+	if target.user != "ubuntu" || target.port != 2222 || target.privateKeyFile != "/keys/ubuntu" {
+		t.Error("Node settings expected, got:", target)
+	}
+}
+
+// serveHostKey accepts SSH handshakes offering the key, connections are closed after the key is sent
+func serveHostKey(t *testing.T, signer ssh.Signer) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				ssh.NewServerConn(conn, config)
+				conn.Close()
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestExecTransportPinnedHostKey(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := serveHostKey(t, signer)
+	fingerprint := ssh.FingerprintSHA256(signer.PublicKey())
+	tempDir := t.TempDir()
+
+	target := &sshTarget{host: "127.0.0.1", port: port, user: "cluster", hostKey: fingerprint, tempDir: tempDir}
+	args, err := (&execTransport{}).sshArgs(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	knownHosts := filepath.Join(tempDir, fmt.Sprintf("known_hosts-127.0.0.1-%d", port))
+	joined := strings.Join(args, " ")
+	if !strings.Contains(joined, `-o UserKnownHostsFile="`+knownHosts+`" -o StrictHostKeyChecking=yes`) || strings.Contains(joined, "StrictHostKeyChecking=no") {
+		t.Error("Pinned key must be checked strictly:", args)
+	}
+	content, err := ioutil.ReadFile(knownHosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := knownhosts.Line([]string{fmt.Sprintf("[127.0.0.1]:%d", port)}, signer.PublicKey()) + "\n"
+	if string(content) != expected {
+		t.Errorf("Unexpected known_hosts:\n%s", content)
+	}
+
+	target = &sshTarget{host: "localhost", port: port, user: "cluster", hostKey: "SHA256:other", tempDir: tempDir}
+	if _, err := (&execTransport{}).sshArgs(target); err == nil || !strings.Contains(err.Error(), "host key mismatch") {
+		t.Error("Wrong key must be refused:", err)
+	}
+
+	bastion := bastionTargets([]bastionHost{{Host: "127.0.0.1", Port: port, User: "jump", PrivateKey: "/keys/jump", HostKey: fingerprint}})
+	target = &sshTarget{host: "10.0.0.1", port: defaultSSHPort, user: "cluster", privateKeyFile: "/keys/cluster", jumps: bastion}
+	args, err = (&execTransport{}).sshArgs(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := fmt.Sprintf(`ProxyCommand=ssh jump@127.0.0.1 -p %d -o UserKnownHostsFile=\"%s\" -o StrictHostKeyChecking=yes -i /keys/jump -W '[%%h]:%%p'`, port, knownHosts)
+	if !contains(args, proxy) {
+		t.Error("Bastion must be reached by own key and checked against pinned key:", args)
+	}
+}
@@ -0,0 +1,246 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// execTransport runs commands using ssh, sshpass (Linux) and plink (Windows) executables found in PATH
+type execTransport struct {
+}
+
+// knownHostsFiles keeps known_hosts files with verified pinned keys by host address during the command
+var knownHostsFiles = struct {
+	sync.Mutex
+	byAddress map[string]string
+}{byAddress: make(map[string]string)}
+
+// pinnedKnownHosts returns known_hosts file with the key offered by target, the key must match pinned fingerprint
+// or is pinned on first use.
+// ssh given this file with StrictHostKeyChecking=yes refuses any other key
+func pinnedKnownHosts(target *sshTarget) (string, error) {
+	address := net.JoinHostPort(target.host, strconv.Itoa(target.port))
+	knownHostsFiles.Lock()
+	file, ok := knownHostsFiles.byAddress[address]
+	knownHostsFiles.Unlock()
+	if ok {
+		return file, nil
+	}
+	key, err := scanHostPublicKey(target)
+	if err != nil {
+		return "", fmt.Errorf("unable to get host key of %s: %v", target.host, err)
+	}
+	if err := pinnedHostKeyCallback(target)(address, nil, key); err != nil {
+		return "", err
+	}
+	dir := target.tempDir
+	if len(dir) == 0 {
+		dir = os.TempDir()
+	}
+	file = filepath.Join(dir, fmt.Sprintf("known_hosts-%s-%d", strings.ReplaceAll(target.host, ":", "_"), target.port))
+	line := knownhosts.Line([]string{knownhosts.Normalize(address)}, key) + "\n"
+	if err := ioutil.WriteFile(file, []byte(line), 0600); err != nil {
+		return "", err
+	}
+	knownHostsFiles.Lock()
+	knownHostsFiles.byAddress[address] = file
+	knownHostsFiles.Unlock()
+	return file, nil
+}
+
+func (t *execTransport) sshArgs(target *sshTarget) ([]string, error) {
+	args := make([]string, 0)
+	args = append(args, target.user+"@"+target.host)
+	if target.port != defaultSSHPort {
+		args = append(args, "-p", strconv.Itoa(target.port))
+	}
+	if len(target.hostKey) > 0 || target.pinHostKey != nil {
+		knownHosts, err := pinnedKnownHosts(target)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-o", `UserKnownHostsFile="`+knownHosts+`"`, "-o", "StrictHostKeyChecking=yes")
+	} else if !target.strictHostKeyChecking {
+		args = append(args, "-o StrictHostKeyChecking=no")
+	}
+	if len(target.jumps) > 0 {
+		proxyCommand, err := t.proxyCommand(target.jumps[len(target.jumps)-1])
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-o", "ProxyCommand="+proxyCommand)
+	}
+	if len(target.privateKeyFile) > 0 {
+		args = append(args, "-i")
+		args = append(args, target.privateKeyFile)
+	}
+	if target.identitiesOnly {
+		args = append(args, "-o IdentitiesOnly=yes")
+	}
+	return args, nil
+}
+
+// proxyCommand returns ssh command which forwards connection through the jump. Unlike ssh -J every hop
+// is checked against own pinned host key and uses own private key
+func (t *execTransport) proxyCommand(jump *sshTarget) (string, error) {
+	args, err := t.sshArgs(jump)
+	if err != nil {
+		return "", err
+	}
+	// ProxyCommand expands %h and %p, other percent signs must be doubled
+	return strings.ReplaceAll(shellquote.Join(append([]string{"ssh"}, args...)...), "%", "%%") + " -W '[%h]:%p'", nil
+}
+
+func (t *execTransport) command(ctx context.Context, target *sshTarget, command string) (*exec.Cmd, error) {
+	if len(target.password) > 0 && runtime.GOOS == "windows" {
+
+		tmp := filepath.Join(target.tempDir, fmt.Sprintf("%s@%s-cmd", target.user, target.host))
+		ioutil.WriteFile(tmp, []byte(command), os.ModePerm) // write command(s) to file
+
+		argsPas := make([]string, 0)
+		argsPas = append(argsPas, "-batch")
+		argsPas = append(argsPas, "-no-antispoof") // TODO: check this option
+		argsPas = append(argsPas, "-ssh")
+		argsPas = append(argsPas, "-P")
+		argsPas = append(argsPas, strconv.Itoa(target.port))
+		argsPas = append(argsPas, "-pw")
+		argsPas = append(argsPas, target.password)
+		if len(target.hostKey) > 0 {
+			argsPas = append(argsPas, "-hostkey", target.hostKey)
+		}
+		argsPas = append(argsPas, "-m")
+		argsPas = append(argsPas, tmp)
+		argsPas = append(argsPas, fmt.Sprintf("%s@%s", target.user, target.host))
+
+		return exec.CommandContext(ctx, "plink", argsPas[:]...), nil
+	}
+	args, err := t.sshArgs(target)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, command)
+	if len(target.password) == 0 {
+		return exec.CommandContext(ctx, "ssh", args[:]...), nil
+	}
+	argsPas := make([]string, 0)
+	argsPas = append(argsPas, "-p"+target.password)
+	argsPas = append(argsPas, "ssh")
+	argsPas = append(argsPas, args...)
+	return exec.CommandContext(ctx, "sshpass", argsPas[:]...), nil
+}
+
+func (t *execTransport) run(ctx context.Context, target *sshTarget, command string, stdin io.Reader, stdout, stderr io.Writer) error {
+	cmd, err := t.command(ctx, target, command)
+	if err != nil {
+		return err
+	}
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	// ssh exits with 255 if connection fails
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() != 255 {
+		return &remoteExitError{exitErr.ExitCode(), err}
+	}
+	return err
+}
+
+func (t *execTransport) interactive(target *sshTarget, command string) error {
+	sshArgs, err := t.sshArgs(target)
+	if err != nil {
+		return err
+	}
+	args := append([]string{"-t"}, sshArgs...)
+	if len(command) > 0 {
+		args = append(args, command)
+	}
+	cmd := exec.Command("ssh", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() != 255 {
+		return &remoteExitError{exitErr.ExitCode(), err}
+	}
+	return err
+}
+
+func (t *execTransport) sftp(target *sshTarget) (*sftp.Client, error) {
+	args, err := t.sshArgs(target)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, "-s", "sftp")
+	cmd := exec.Command("ssh", args...)
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	// ssh exits when client closes the pipes
+	go cmd.Wait()
+	return sftp.NewClientPipe(r, w)
+}
+
+func (t *execTransport) copy(ctx context.Context, target *sshTarget, size int64, mode os.FileMode, fileName string, contents io.Reader, destination string) error {
+	command := shellquote.Join("scp", "-t", destination)
+
+	args, err := t.sshArgs(target)
+	if err != nil {
+		return err
+	}
+	args = append(args, command)
+	cmd := exec.CommandContext(ctx, "ssh", args[:]...)
+
+	w, err := cmd.StdinPipe()
+
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		return err
+	}
+
+	errors := make(chan error)
+
+	go func() {
+		errors <- cmd.Wait()
+	}()
+
+	fmt.Fprintf(w, "C%#o %d %s\n", mode, size, fileName)
+	io.Copy(w, contents)
+	fmt.Fprint(w, "\x00")
+	w.Close()
+
+	return <-errors
+}
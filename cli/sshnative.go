@@ -0,0 +1,417 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/sftp"
+	gc "github.com/untillpro/gochips"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// nativeTransport runs commands using built-in SSH client, no external executables required.
+// One authenticated connection per host is kept open until command finishes, sessions are multiplexed over it
+type nativeTransport struct {
+}
+
+type sshConnection struct {
+	sync.Mutex
+	client *ssh.Client
+}
+
+var sshConnections = struct {
+	sync.Mutex
+	byKey map[string]*sshConnection
+}{byKey: make(map[string]*sshConnection)}
+
+const sshDialTimeout = 30 * time.Second
+
+var keyPassphrases = struct {
+	sync.Mutex
+	byFile map[string][]byte
+}{byFile: make(map[string][]byte)}
+
+func (t *nativeTransport) address(target *sshTarget) string {
+	return net.JoinHostPort(target.host, strconv.Itoa(target.port))
+}
+
+// connectionKey identifies pooled connection, connections authenticated by the key file only are not shared with others
+func (t *nativeTransport) connectionKey(target *sshTarget) string {
+	key := target.user + "@" + t.address(target)
+	if target.identitiesOnly {
+		key += " " + target.privateKeyFile
+	}
+	return key
+}
+
+// connection returns opened connection to the target, the connection is established on first use
+func (t *nativeTransport) connection(target *sshTarget) (*sshConnection, error) {
+	key := t.connectionKey(target)
+	sshConnections.Lock()
+	conn, ok := sshConnections.byKey[key]
+	if !ok {
+		conn = &sshConnection{}
+		sshConnections.byKey[key] = conn
+	}
+	sshConnections.Unlock()
+
+	conn.Lock()
+	defer conn.Unlock()
+	if conn.client == nil {
+		client, err := t.dial(target)
+		if err != nil {
+			return nil, err
+		}
+		gc.Verbose("SSH connection established", key)
+		conn.client = client
+	}
+	return conn, nil
+}
+
+// newSession opens new session over the target connection, broken connection is re-established once
+func (t *nativeTransport) newSession(target *sshTarget) (*ssh.Session, error) {
+	conn, err := t.connection(target)
+	if err != nil {
+		return nil, err
+	}
+	conn.Lock()
+	session, err := conn.client.NewSession()
+	if err != nil {
+		gc.Verbose("SSH connection lost, reconnecting", t.connectionKey(target), err)
+		conn.client.Close()
+		conn.client = nil
+	}
+	conn.Unlock()
+	if err == nil {
+		return session, nil
+	}
+	conn, err = t.connection(target)
+	if err != nil {
+		return nil, err
+	}
+	conn.Lock()
+	defer conn.Unlock()
+	return conn.client.NewSession()
+}
+
+// closeSSHConnections closes all connections opened during the command
+func closeSSHConnections() {
+	sshConnections.Lock()
+	defer sshConnections.Unlock()
+	for key, conn := range sshConnections.byKey {
+		conn.Lock()
+		if conn.client != nil {
+			conn.client.Close()
+			conn.client = nil
+		}
+		conn.Unlock()
+		delete(sshConnections.byKey, key)
+	}
+}
+
+// dialTCP opens network connection to the target, through the last of jump hosts if any
+func (t *nativeTransport) dialTCP(target *sshTarget) (net.Conn, error) {
+	if len(target.jumps) == 0 {
+		dialer := net.Dialer{Timeout: sshDialTimeout}
+		return dialer.DialContext(commandContext(), "tcp", t.address(target))
+	}
+	jump, err := t.connection(target.jumps[len(target.jumps)-1])
+	if err != nil {
+		return nil, err
+	}
+	jump.Lock()
+	defer jump.Unlock()
+	return jump.client.Dial("tcp", t.address(target))
+}
+
+func (t *nativeTransport) dial(target *sshTarget) (*ssh.Client, error) {
+	config, err := t.clientConfig(target)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := t.dialTCP(target)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, t.address(target), config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+func (t *nativeTransport) clientConfig(target *sshTarget) (*ssh.ClientConfig, error) {
+	hostKeyCallback, err := t.hostKeyCallback(target)
+	if err != nil {
+		return nil, err
+	}
+	auth := make([]ssh.AuthMethod, 0)
+	signers, err := t.signers(target)
+	if err != nil {
+		return nil, err
+	}
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+	if len(target.password) > 0 {
+		password := target.password
+		auth = append(auth, ssh.Password(password))
+		auth = append(auth, ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+			answers := make([]string, len(questions))
+			for i := range questions {
+				answers[i] = password
+			}
+			return answers, nil
+		}))
+	}
+	if len(target.password) == 0 && len(target.privateKeyFile) == 0 {
+		auth = append(auth, ssh.PasswordCallback(func() (string, error) {
+			return readPasswordPrompt(fmt.Sprintf("%s@%s's password", target.user, target.host)), nil
+		}))
+	}
+	return &ssh.ClientConfig{
+		User:            target.user,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sshDialTimeout,
+	}, nil
+}
+
+func (t *nativeTransport) hostKeyCallback(target *sshTarget) (ssh.HostKeyCallback, error) {
+	if len(target.hostKey) > 0 || !target.strictHostKeyChecking {
+		return pinnedHostKeyCallback(target), nil
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return nil, err
+	}
+	return knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+}
+
+// signers returns keys loaded to ssh-agent followed by the key from private key file
+func (t *nativeTransport) signers(target *sshTarget) ([]ssh.Signer, error) {
+	signers := make([]ssh.Signer, 0)
+	if sshAgent := getSSHAgent(); sshAgent != nil && !target.identitiesOnly {
+		agentSigners, err := sshAgent.Signers()
+		if err == nil {
+			signers = append(signers, agentSigners...)
+		}
+	}
+	if len(target.privateKeyFile) == 0 || !FileExists(target.privateKeyFile) {
+		return signers, nil
+	}
+	// Key loaded to the agent does not require passphrase to be typed again
+	signer, err := t.parsePrivateKeyFile(target.privateKeyFile, len(signers) == 0)
+	if err != nil {
+		return nil, err
+	}
+	if signer != nil {
+		signers = append(signers, signer)
+	}
+	return signers, nil
+}
+
+// rememberKeyPassphrase saves passphrase of the key file, so user is not asked for it
+func rememberKeyPassphrase(privateKeyFile, passphrase string) {
+	keyPassphrases.Lock()
+	defer keyPassphrases.Unlock()
+	keyPassphrases.byFile[privateKeyFile] = []byte(passphrase)
+}
+
+func (t *nativeTransport) parsePrivateKeyFile(privateKeyFile string, askPassphrase bool) (ssh.Signer, error) {
+	key, err := parseRawPrivateKeyFile(privateKeyFile, askPassphrase)
+	if key == nil || err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromKey(key)
+}
+
+// parseRawPrivateKeyFile returns private key from the file, passphrase of encrypted key is asked once per command.
+// Nil is returned for encrypted key if passphrase is not known yet and must not be asked
+func parseRawPrivateKeyFile(privateKeyFile string, askPassphrase bool) (interface{}, error) {
+	pemBytes, err := ioutil.ReadFile(privateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ssh.ParseRawPrivateKey(pemBytes)
+	var missingErr *ssh.PassphraseMissingError
+	if !errors.As(err, &missingErr) {
+		return key, err
+	}
+	keyPassphrases.Lock()
+	defer keyPassphrases.Unlock()
+	passphrase, ok := keyPassphrases.byFile[privateKeyFile]
+	if !ok {
+		if !askPassphrase {
+			return nil, nil
+		}
+		gc.Info("Private key file is encrypted: " + privateKeyFile)
+		passphrase = []byte(readKeyPassword())
+	}
+	key, err = ssh.ParseRawPrivateKeyWithPassphrase(pemBytes, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	keyPassphrases.byFile[privateKeyFile] = passphrase
+	return key, nil
+}
+
+func (t *nativeTransport) run(ctx context.Context, target *sshTarget, command string, stdin io.Reader, stdout, stderr io.Writer) error {
+	session, err := t.newSession(target)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	// Terminal input is not forwarded, remote side has no tty to prompt on
+	if stdin != os.Stdin {
+		session.Stdin = stdin
+	}
+	session.Stdout = stdout
+	session.Stderr = stderr
+	err = session.Start(command)
+	if err == nil {
+		err = t.wait(ctx, session)
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return &remoteExitError{exitErr.ExitStatus(), err}
+	}
+	return err
+}
+
+// wait waits for the session to finish, remote command is killed when ctx is done
+func (t *nativeTransport) wait(ctx context.Context, session *ssh.Session) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		return ctx.Err()
+	}
+}
+
+func (t *nativeTransport) interactive(target *sshTarget, command string) error {
+	session, err := t.newSession(target)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	session.Stdin = os.Stdin
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+
+	fd := int(os.Stdin.Fd())
+	if terminal.IsTerminal(fd) {
+		width, height, err := terminal.GetSize(fd)
+		if err != nil {
+			width, height = 80, 24
+		}
+		term := os.Getenv("TERM")
+		if len(term) == 0 {
+			term = "xterm"
+		}
+		modes := ssh.TerminalModes{ssh.ECHO: 1, ssh.TTY_OP_ISPEED: 14400, ssh.TTY_OP_OSPEED: 14400}
+		if err := session.RequestPty(term, height, width, modes); err != nil {
+			return err
+		}
+		state, err := terminal.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		defer terminal.Restore(fd, state)
+		stop := make(chan struct{})
+		defer close(stop)
+		go followTerminalSize(fd, width, height, session, stop)
+	}
+
+	if len(command) == 0 {
+		err = session.Shell()
+	} else {
+		err = session.Start(command)
+	}
+	if err == nil {
+		err = session.Wait()
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return &remoteExitError{exitErr.ExitStatus(), err}
+	}
+	return err
+}
+
+// followTerminalSize passes local terminal size changes to remote tty. Size is polled since SIGWINCH is not available on Windows
+func followTerminalSize(fd, width, height int, session *ssh.Session, stop chan struct{}) {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			w, h, err := terminal.GetSize(fd)
+			if err == nil && (w != width || h != height) {
+				width, height = w, h
+				session.WindowChange(height, width)
+			}
+		}
+	}
+}
+
+func (t *nativeTransport) sftp(target *sshTarget) (*sftp.Client, error) {
+	conn, err := t.connection(target)
+	if err != nil {
+		return nil, err
+	}
+	return sftp.NewClient(conn.client)
+}
+
+func (t *nativeTransport) copy(ctx context.Context, target *sshTarget, size int64, mode os.FileMode, fileName string, contents io.Reader, destination string) error {
+	session, err := t.newSession(target)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	w, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := session.Start(shellquote.Join("scp", "-t", destination)); err != nil {
+		w.Close()
+		return err
+	}
+
+	fmt.Fprintf(w, "C%#o %d %s\n", mode, size, fileName)
+	io.Copy(w, contents)
+	fmt.Fprint(w, "\x00")
+	w.Close()
+
+	return t.wait(ctx, session)
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"regexp"
+	"sync"
+
+	gc "github.com/untillpro/gochips"
+)
+
+var sudoRegexp = regexp.MustCompile(`\bsudo\b`)
+
+// sudoAskpassPrefix reads sudo password from the first line of stdin to 0600 file in private temp dir and puts `sudo` wrapper
+// to the PATH. Wrapper runs real sudo with askpass helper which answers the prompt from the file, so password never appears
+// in the command line or environment and stdin of the command itself is not touched, e.g. `sudo yes | sudo ufw enable` works.
+// PATH is inherited by scripts, so sudo calls from scripts are answered as well. Wrapper works through PATH only,
+// scripts which call sudo by full path, e.g. /usr/bin/sudo, bypass it and hang at the password prompt
+const sudoAskpassPrefix = `swarmgo_sudo_dir=$(mktemp -d) && trap 'rm -rf "$swarmgo_sudo_dir"' EXIT && ` +
+	`(umask 077 && IFS= read -r password && printf '%s\n' "$password" > "$swarmgo_sudo_dir/password") && ` +
+	`printf '#!/bin/sh\nexec cat "%s/password"\n' "$swarmgo_sudo_dir" > "$swarmgo_sudo_dir/askpass" && ` +
+	`printf '#!/bin/sh\nSUDO_ASKPASS="%s/askpass" exec %s -A "$@"\n' "$swarmgo_sudo_dir" "$(command -v sudo)" > "$swarmgo_sudo_dir/sudo" && ` +
+	`chmod 700 "$swarmgo_sudo_dir/askpass" "$swarmgo_sudo_dir/sudo" && export PATH="$swarmgo_sudo_dir:$PATH" && `
+
+// withSudoPassword returns command which answers sudo prompts with password passed as the first line of stdin.
+// Commands which don't call sudo are returned as is
+func withSudoPassword(command string) (string, bool) {
+	if !sudoRegexp.MatchString(command) {
+		return command, false
+	}
+	// Newline before closing brace allows command to end with heredoc
+	return sudoAskpassPrefix + "{ " + command + "\n}", true
+}
+
+var sudoPassword = struct {
+	sync.Mutex
+	value string
+}{}
+
+// getSudoPassword asks user for the cluster user sudo password once per command
+func getSudoPassword(clusterFile *clusterFile) string {
+	sudoPassword.Lock()
+	defer sudoPassword.Unlock()
+	if len(sudoPassword.value) == 0 {
+		sudoPassword.value = readPasswordPrompt("Specify sudo password for " + clusterFile.ClusterUserName)
+		gc.ExitIfFalse(len(sudoPassword.value) > 0, "Sudo password must not be empty")
+	}
+	return sudoPassword.value
+}
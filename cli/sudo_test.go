@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSudo checks that password is given by askpass helper and runs the command
+const fakeSudo = `#!/bin/sh
+[ "$1" = "-A" ] || { echo "askpass is not used" >&2; exit 1; }
+shift
+[ "$("$SUDO_ASKPASS")" = "$EXPECTED_PASSWORD" ] || { echo "wrong password" >&2; exit 1; }
+exec "$@"
+`
+
+func TestWithSudoPassword(t *testing.T) {
+	if command, wrapped := withSudoPassword("docker -v"); wrapped || command != "docker -v" {
+		t.Error("Command without sudo must not be wrapped, got:", command)
+	}
+
+	dir, err := ioutil.TempDir("", "swarmgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "sudo"), []byte(fakeSudo), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	password := `p@$$ 'w"\d`
+	command, wrapped := withSudoPassword("sudo echo piped | sudo cat && cat << EOF\nheredoc\nEOF")
+	if !wrapped {
+		t.Fatal("Command with sudo must be wrapped")
+	}
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), "PATH="+dir+":"+os.Getenv("PATH"), "EXPECTED_PASSWORD="+password)
+	cmd.Stdin = strings.NewReader(password + "\n")
+	out, err := cmd.CombinedOutput()
+	if err != nil || string(out) != "piped\nheredoc\n" {
+		t.Error("Unexpected output:", string(out), err)
+	}
+
+	// Password must not be inherited by processes started by the command
+	command, _ = withSudoPassword("sudo true && env")
+	cmd = exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), "PATH="+dir+":"+os.Getenv("PATH"), "EXPECTED_PASSWORD=secret")
+	cmd.Stdin = strings.NewReader("secret\n")
+	out, err = cmd.CombinedOutput()
+	if err != nil || strings.Count(string(out), "secret") != 1 {
+		t.Error("Password leaked to environment:", string(out), err)
+	}
+}
@@ -10,13 +10,10 @@ package cli
 
 import (
 	"fmt"
-	"io/ioutil"
-	"path/filepath"
 	"strings"
 
 	"github.com/spf13/cobra"
 	gc "github.com/untillpro/gochips"
-	"gopkg.in/yaml.v2"
 )
 
 const (
@@ -34,18 +31,21 @@ func AddToSwarm(manager bool, args []string) {
 	nodesFromYml := getNodesFromYml(getWorkingDir())
 	gc.ExitIfFalse(len(nodesFromYml) > 0, "Can't find nodes from nodes.yml. Add some nodes first")
 
+	if len(args) > 0 {
+		args = nodeAliases(getNodesBySelector(getSSHClient(clusterFile), args...))
+	}
 	nodeHostAndNode := make(map[string]node)
 	for _, value := range nodesFromYml {
 		nodeHostAndNode[value.Host] = value
 	}
 	clusterLeaderNode, clusterManagerNodes, clusterWorkerNodes := getHostsFromNodesGroupingBySwarmModeValue(nodesFromYml)
-	if clusterLeaderNode == (node{}) {
+	if len(clusterLeaderNode.Host) == 0 {
 		gc.ExitIfFalse(manager, "Use `-manager` flag to init swarm")
 		gc.ExitIfFalse(len(args) > 0, "Need to pass at least one alias to init swarm")
 	}
 	nodesWithoutSwarm := make([]node, 0, len(nodesFromYml))
 	for _, nodeFromYml := range nodesFromYml {
-		if nodeFromYml == clusterLeaderNode || containsNode(clusterManagerNodes, nodeFromYml) ||
+		if nodeFromYml.Host == clusterLeaderNode.Host || containsNode(clusterManagerNodes, nodeFromYml) ||
 			containsNode(clusterWorkerNodes, nodeFromYml) {
 			if contains(args, nodeFromYml.Alias) {
 				gc.Info(nodeFromYml.Alias + " already in swarm")
@@ -62,7 +62,7 @@ func AddToSwarm(manager bool, args []string) {
 	gc.ExitIfFalse(len(nodesWithoutSwarm) > 0, "All nodes already in swarm")
 
 	var nodeVar node
-	if clusterLeaderNode == (node{}) {
+	if len(clusterLeaderNode.Host) == 0 {
 		nodeVar, nodesWithoutSwarm = initSwarm(nodesWithoutSwarm, args,
 			clusterFile)
 		nodeHostAndNode[nodeVar.Host] = nodeVar
@@ -100,22 +100,18 @@ func AddToSwarm(manager bool, args []string) {
 		nodes[i] = value
 		i++
 	}
-	marshaledNode, err := yaml.Marshal(&nodes)
-	gc.ExitIfError(err)
-
-	nodesFilePath := filepath.Join(getWorkingDir(), nodesFileName)
-
-	gc.ExitIfError(ioutil.WriteFile(nodesFilePath, marshaledNode, 0600))
+	writeNodesYml(nodes)
 
 	gc.ExitIfFalse(len(errMsgs) == 0, "Failed to install on some node(s)")
 }
 
 // swarmCmd represents the swarm command
 var swarmCmd = &cobra.Command{
-	Use:   "swarm -m <Alias1> <Alias2> or swarm without params (you should create one manager before doing that)",
+	Use:   "swarm -m <selector...> or swarm without params (you should create one manager before doing that)",
 	Short: "swarm -m installs managers on given node, swarm installs workers",
-	Long: `swarm with -m installs swarm manager nodes on given Aliases, swarm installs swarm workers on other nodes in
- cluster`,
+	Long: `swarm with -m installs swarm manager nodes on selected nodes, first selected node becomes the leader. swarm installs swarm workers on other nodes in
+ cluster.
+` + selectorHelp,
 	Run: func(cmd *cobra.Command, args []string) {
 		initCommand("swarm")
 		defer finitCommand()
@@ -127,7 +123,7 @@ var swarmCmd = &cobra.Command{
 	},
 }
 
-func getToken(mode, host string, client *SSHClient, targetHost string) (string, error) {
+func getToken(mode, host string, client Executor, targetHost string) (string, error) {
 	output, err := client.Exec(host, "$sudo docker swarm join-token "+mode) // "$" prefix masks output
 	cmd := ""
 	if err == nil {
@@ -137,7 +133,7 @@ func getToken(mode, host string, client *SSHClient, targetHost string) (string,
 	return cmd, err
 }
 
-func reloadUfwAndDocker(host string, client *SSHClient) error {
+func reloadUfwAndDocker(host string, client Executor) error {
 	logWithPrefix(host, "Restarting ufw...")
 	_, err := client.Exec(host, "sudo ufw reload")
 	if err != nil {
@@ -178,7 +174,7 @@ func findNodeByAliasFromNodesYml(alias string, nodesFromYml []node) (node, int)
 			index = i
 		}
 	}
-	if leaderNode == (node{}) {
+	if len(leaderNode.Host) == 0 {
 		gc.Info("Can't find host by given alias in nodes.yml, choose it interactive")
 		alias := numberHostsFromNodesFile(nodesFromYml)
 		return findNodeByAliasFromNodesYml(alias, nodesFromYml)
@@ -206,11 +202,13 @@ func getHostsFromNodesGroupingBySwarmModeValue(nodes []node) (node, []node, []no
 	return clusterLeaderHost, clusterManagerHosts, clusterWorkersHost
 }
 
-func configUfwToWorkInSwarmMode(host string, client *SSHClient) error {
+func configUfwToWorkInSwarmMode(host string, client Executor) error {
 	commands := []SSHCommand{
 		SSHCommand{
-			cmd:   "sudo apt-get -y install ufw",
-			title: "Installing ufw",
+			cmd:     "sudo apt-get -y install ufw",
+			title:   "Installing ufw",
+			timeout: aptCommandTimeout,
+			retry:   aptRetryPolicy,
 		},
 		SSHCommand{
 			cmd:   "sudo ufw allow 22/tcp",
@@ -12,9 +12,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -45,7 +43,7 @@ var (
 
 type infoForCopy struct {
 	host   string
-	client *SSHClient
+	client Executor
 }
 
 var swarmpromCmd = &cobra.Command{
@@ -71,13 +69,22 @@ func DeploySwarmprom(noalerts bool, slackWebhook string, grafanaPass string, pro
 	checkSwarmNodeLabelTrue(clusterFile, firstEntry, prometheusLabel, true)
 
 	if len(grafanaPass) == 0 {
-		grafanaPass = readPasswordPrompt(fmt.Sprintf("Specify [%s] password (access to Grafana web-ui)", clusterFile.GrafanaUser))
+		grafanaPass = clusterFile.GrafanaPassword
 	}
-	if len(alertMgrPass) == 0 {
-		alertMgrPass = readPasswordPrompt(fmt.Sprintf("Specify [%s] password (access to Alertmanager web-ui)", clusterFile.AlertmanagerUser))
+	if len(grafanaPass) == 0 {
+		grafanaPass = getCredential(credGrafanaPassword, func() string {
+			return readPasswordPrompt(fmt.Sprintf("Specify [%s] password (access to Grafana web-ui)", clusterFile.GrafanaUser))
+		})
+	}
+	if len(alertMgrPass) == 0 && len(clusterFile.AlertManagerBasicAuth) == 0 {
+		alertMgrPass = getCredential(credAlertmanagerPassword, func() string {
+			return readPasswordPrompt(fmt.Sprintf("Specify [%s] password (access to Alertmanager web-ui)", clusterFile.AlertmanagerUser))
+		})
 	}
-	if len(prometheusPass) == 0 {
-		prometheusPass = readPasswordPrompt(fmt.Sprintf("Specify [%s] password (access to Prometheus web-ui)", clusterFile.PrometheusUser))
+	if len(prometheusPass) == 0 && len(clusterFile.PrometheusBasicAuth) == 0 {
+		prometheusPass = getCredential(credPrometheusPassword, func() string {
+			return readPasswordPrompt(fmt.Sprintf("Specify [%s] password (access to Prometheus web-ui)", clusterFile.PrometheusUser))
+		})
 	}
 
 	clusterFile.GrafanaPassword = grafanaPass
@@ -88,17 +95,13 @@ func DeploySwarmprom(noalerts bool, slackWebhook string, grafanaPass string, pro
 	forCopy := infoForCopy{
 		host, client,
 	}
-	gc.Info("Installing dos2unix")
-	client.ExecOrExit(host, "sudo apt-get install dos2unix")
 	gc.Info("Installing htpasswd")
 	client.ExecOrExit(host, "sudo apt-get install apache2-utils -y")
 
 	gc.Info("Copying files to node")
 
-	clusterFile.PrometheusBasicAuth = client.ExecOrExit(host, fmt.Sprintf("htpasswd -nbB %s \"%s\"", clusterFile.PrometheusUser, prometheusPass))
-	clusterFile.PrometheusBasicAuth = strings.ReplaceAll(clusterFile.PrometheusBasicAuth, "$", "\\$\\$")
-	clusterFile.AlertManagerBasicAuth = client.ExecOrExit(host, fmt.Sprintf("echo $(htpasswd -nbB %s \"%s\")", clusterFile.AlertmanagerUser, alertMgrPass))
-	clusterFile.AlertManagerBasicAuth = strings.ReplaceAll(clusterFile.AlertManagerBasicAuth, "$", "\\$\\$")
+	clusterFile.PrometheusBasicAuth = htpasswdEntry(client, host, clusterFile.PrometheusUser, prometheusPass, clusterFile.PrometheusBasicAuth)
+	clusterFile.AlertManagerBasicAuth = htpasswdEntry(client, host, clusterFile.AlertmanagerUser, alertMgrPass, clusterFile.AlertManagerBasicAuth)
 
 	copyToHost(&forCopy, filepath.ToSlash(filepath.Join(getSourcesDir(), swarmpromFolder)))
 	templateAndCopy(client, host, swarmpromComposeFileName, "~/"+swarmpromComposeFileName, clusterFile)
@@ -129,8 +132,6 @@ func UpgradeAlertmanagerCfg(noalerts bool, slackWebhook string) {
 	forCopy := infoForCopy{
 		host, client,
 	}
-	gc.Info("Installing dos2unix")
-	client.ExecOrExit(host, "sudo apt-get install dos2unix")
 
 	copyToHost(&forCopy, filepath.ToSlash(filepath.Join(getSourcesDir(), alertmanagerFolder)))
 	writeAlertManagerConf(client, host, clusterFile, noalerts)
@@ -154,27 +155,42 @@ func UpgradeAlertmanagerCfg(noalerts bool, slackWebhook string) {
 	}
 }
 
-func templateAndCopy(client *SSHClient, host, localFile, destFile string, tmplExecutor interface{}) {
+func templateAndCopy(client Executor, host, localFile, destFile string, tmplExecutor interface{}) {
 	appliedBuffer := executeTemplateToFile(localFile, tmplExecutor)
 	client.ExecOrExit(host, "cat > "+destFile+" << EOF\n\n"+appliedBuffer.String()+"\nEOF")
 	gc.Verbose(destFile, fmt.Sprintf("Copied and applied by template '%s'->'%s'", localFile, destFile))
 }
 
+// htpasswdEntry hashes password on the host, entry given in configuration is used as is if password is empty.
+// "$" is escaped for docker compose
+func htpasswdEntry(client Executor, host, user, password, given string) string {
+	entry := given
+	if len(password) > 0 || len(given) == 0 {
+		entry = client.ExecOrExit(host, fmt.Sprintf("!htpasswd -nbB %s \"%s\"", user, password)) // "!" prefix masks input
+	}
+	return strings.ReplaceAll(strings.TrimSpace(entry), "$", "\\$\\$")
+}
+
 func getSlackWebhookURL(clusterFile *clusterFile, noalerts bool, slackWebhookURL string) {
 	if !noalerts {
-		if len(slackWebhookURL) == 0 {
-			gc.Info("Enter webhook URL for slack channel", clusterFile.ChannelName)
-			clusterFile.WebhookURL = waitUserInput()
-		} else {
+		switch {
+		case len(slackWebhookURL) > 0:
 			gc.Info("Setting webhook URL for slack channel to ", slackWebhookURL)
 			clusterFile.WebhookURL = slackWebhookURL
+		case len(clusterFile.WebhookURL) > 0:
+			gc.Verbose("Using WebhookURL from configuration")
+		default:
+			clusterFile.WebhookURL = getCredential(credSlackWebhookURL, func() string {
+				gc.Info("Enter webhook URL for slack channel", clusterFile.ChannelName)
+				return waitUserInput()
+			})
 		}
 	} else {
 		clusterFile.WebhookURL = ""
 	}
 }
 
-func writeAlertManagerConf(client *SSHClient, host string, clusterFile *clusterFile, noalerts bool) {
+func writeAlertManagerConf(client Executor, host string, clusterFile *clusterFile, noalerts bool) {
 	alertMgrSrcCfg := alertmanagerSlackConfigPath
 	if noalerts {
 		alertMgrSrcCfg = alertmanagerNoAlertsConfigPath
@@ -183,36 +199,16 @@ func writeAlertManagerConf(client *SSHClient, host string, clusterFile *clusterF
 	templateAndCopy(client, host, alertMgrSrcCfg, "~/"+alertmanagerTargetConfigPath, clusterFile)
 }
 
+// copyToHost syncs local dir to the same path relative to home on the host, text files get LF line endings
 func copyToHost(forCopy *infoForCopy, src string) {
-	info, err := os.Lstat(src)
-	gc.ExitIfError(err)
-	if info.IsDir() {
-		copyDirToHost(src, forCopy)
-	} else {
-		copyFileToHost(src, forCopy)
-	}
-}
-
-func copyDirToHost(dirPath string, forCopy *infoForCopy) {
-	forCopy.client.ExecOrExit(forCopy.host, "mkdir -p "+substringAfter(dirPath,
-		filepath.ToSlash(getSourcesDir())+"/"))
-	dirContent, err := ioutil.ReadDir(dirPath)
-	gc.ExitIfError(err)
-	for _, dirEntry := range dirContent {
-		src := filepath.ToSlash(filepath.Join(dirPath, dirEntry.Name()))
-		copyToHost(forCopy, src)
-	}
-}
-
-func copyFileToHost(filePath string, forCopy *infoForCopy) {
-	relativePath := substringAfter(filePath, filepath.ToSlash(getSourcesDir())+"/")
-	err := forCopy.client.CopyPath(forCopy.host, filePath, relativePath)
-	gc.ExitIfError(err)
-	forCopy.client.ExecOrExit(forCopy.host, "sudo dos2unix "+relativePath)
-	forCopy.client.ExecOrExit(forCopy.host, "sudo chown root:root "+relativePath)
-	forCopy.client.ExecOrExit(forCopy.host, "sudo chmod 777 "+relativePath)
+	relativePath := substringAfter(src, filepath.ToSlash(getSourcesDir())+"/")
+	res, err := forCopy.client.SyncDir(forCopy.host, src, relativePath, SyncOptions{
+		Mode:                 0777,
+		Owner:                "root:root",
+		NormalizeLineEndings: true,
+	})
 	gc.ExitIfError(err)
-	gc.Verbose(relativePath, "copied on host")
+	logWithPrefix(forCopy.host, fmt.Sprintf("%s synced, %d file(s) uploaded, %d unchanged", relativePath, res.Uploaded, res.Skipped))
 }
 
 func postTestMessageToAlertmanager(URL, channelName string) error {
@@ -0,0 +1,213 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/pkg/sftp"
+	gc "github.com/untillpro/gochips"
+)
+
+// SyncOptions control how SyncDir uploads files
+type SyncOptions struct {
+	Mode                 os.FileMode // Mode of all files in the remote dir, modes of uploaded local files are kept if zero
+	Owner                string      // Owner of all files in the remote dir as user[:group], changed by sudo if not empty
+	NormalizeLineEndings bool        // Replace CRLF by LF in text files before upload
+}
+
+// SyncResult is a result of SyncDir
+type SyncResult struct {
+	Uploaded int
+	Skipped  int // Files with the same checksum on the host
+}
+
+// syncFile is a local file prepared for upload
+type syncFile struct {
+	path     string // Relative to synced dir, uses forward slashes
+	mode     os.FileMode
+	contents []byte
+	checksum string
+}
+
+// normalizeLineEndings replaces CRLF by LF, files with zero bytes are binary and kept as is
+func normalizeLineEndings(contents []byte) []byte {
+	if bytes.IndexByte(contents, 0) >= 0 {
+		return contents
+	}
+	return bytes.ReplaceAll(contents, []byte("\r\n"), []byte("\n"))
+}
+
+// readSyncFiles reads files and lists directories under localDir, directories go before their content
+func readSyncFiles(localDir string, normalize bool) ([]syncFile, []string, error) {
+	files := make([]syncFile, 0)
+	dirs := make([]string, 0)
+	err := filepath.Walk(localDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localDir, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			dirs = append(dirs, rel)
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if normalize {
+			contents = normalizeLineEndings(contents)
+		}
+		sum := sha256.Sum256(contents)
+		files = append(files, syncFile{rel, info.Mode().Perm(), contents, hex.EncodeToString(sum[:])})
+		return nil
+	})
+	return files, dirs, err
+}
+
+// remoteChecksumsCmd lists sha256sum of files in the remote dir, output is empty if dir doesn't exist
+func remoteChecksumsCmd(remoteDir string) string {
+	return fmt.Sprintf("cd %s 2> /dev/null && find . -type f -exec sha256sum {} + ; true", shellquote.Join(remoteDir))
+}
+
+// parseChecksums parses sha256sum output to checksums by path relative to the dir
+func parseChecksums(out string) map[string]string {
+	res := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), "  ", 2)
+		if len(fields) == 2 {
+			res[strings.TrimPrefix(fields[1], "./")] = fields[0]
+		}
+	}
+	return res
+}
+
+func changedSyncFiles(files []syncFile, remoteChecksums map[string]string) []syncFile {
+	res := make([]syncFile, 0)
+	for _, f := range files {
+		if remoteChecksums[f.path] != f.checksum {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// remoteOwnerAndModeCmd sets owner and mode of all files in the remote dir by single command
+func remoteOwnerAndModeCmd(remoteDir string, options SyncOptions) string {
+	cmds := make([]string, 0, 2)
+	if len(options.Owner) > 0 {
+		cmds = append(cmds, fmt.Sprintf("sudo find %s -type f -exec chown %s {} +", shellquote.Join(remoteDir), shellquote.Join(options.Owner)))
+	}
+	if options.Mode != 0 {
+		cmds = append(cmds, fmt.Sprintf("sudo find %s -type f -exec chmod %#o {} +", shellquote.Join(remoteDir), options.Mode.Perm()))
+	}
+	return strings.Join(cmds, " && ")
+}
+
+// SyncDir uploads files from localDir which differ from files in remoteDir over single SFTP session, files are compared by checksum.
+// Files which exist in remoteDir only are kept
+func (c *SSHClient) SyncDir(host string, localDir, remoteDir string, options SyncOptions) (*SyncResult, error) {
+	files, dirs, err := readSyncFiles(localDir, options.NormalizeLineEndings)
+	if err != nil {
+		return nil, err
+	}
+	out, err := c.Exec(host, "$"+remoteChecksumsCmd(remoteDir)) // "$" prefix masks output
+	if err != nil {
+		return nil, err
+	}
+	changed := changedSyncFiles(files, parseChecksums(out))
+	res := &SyncResult{Uploaded: len(changed), Skipped: len(files) - len(changed)}
+	if c.Verbose {
+		gc.Verbose(c.prefixed(host, fmt.Sprintf("Syncing [%s] to [%s]: %d file(s) changed, %d unchanged", localDir, remoteDir, res.Uploaded, res.Skipped)))
+	}
+	if len(changed) > 0 {
+		ctx, cancel := timeoutContext(c.Timeout)
+		defer cancel()
+		start := time.Now()
+		err := c.upload(host, remoteDir, dirs, changed, options.Mode == 0, ctx.Done())
+		if ctx.Err() != nil {
+			err = contextError(ctx, time.Since(start))
+		}
+		writeTranscript(host, transcriptNote(c.User, fmt.Sprintf("sftp upload of %d file(s) from %s to %s", len(changed), localDir, remoteDir), err))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cmd := remoteOwnerAndModeCmd(remoteDir, options); len(cmd) > 0 {
+		if _, err := c.Exec(host, cmd); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// upload writes files over SFTP, session is closed when done is closed
+func (c *SSHClient) upload(host string, remoteDir string, dirs []string, files []syncFile, keepModes bool, done <-chan struct{}) error {
+	client, err := c.transport.sftp(c.target(host))
+	if err != nil {
+		return fmt.Errorf("unable to start sftp session: %v", err)
+	}
+	defer client.Close()
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-done:
+			client.Close()
+		case <-finished:
+		}
+	}()
+
+	for _, d := range dirs {
+		if err := client.MkdirAll(path.Join(remoteDir, d)); err != nil {
+			return fmt.Errorf("unable to create %s: %v", path.Join(remoteDir, d), err)
+		}
+	}
+	for _, f := range files {
+		if err := uploadFile(client, path.Join(remoteDir, f.path), f, keepModes); err != nil {
+			return fmt.Errorf("unable to upload %s: %v", path.Join(remoteDir, f.path), err)
+		}
+	}
+	return nil
+}
+
+func uploadFile(client *sftp.Client, remotePath string, f syncFile, keepMode bool) error {
+	w, err := client.OpenFile(remotePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(f.contents); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if keepMode {
+		return client.Chmod(remotePath, f.mode)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+// sftpTransport serves SFTP from memory, checksums command is answered with given output
+type sftpTransport struct {
+	stubTransport
+	commands []string
+	fs       sftp.Handlers
+}
+
+func (t *sftpTransport) run(ctx context.Context, target *sshTarget, command string, stdin io.Reader, stdout, stderr io.Writer) error {
+	t.commands = append(t.commands, command)
+	return t.stubTransport.run(ctx, target, command, stdin, stdout, stderr)
+}
+
+func (t *sftpTransport) sftp(target *sshTarget) (*sftp.Client, error) {
+	serverConn, clientConn := net.Pipe()
+	go sftp.NewRequestServer(serverConn, t.fs).Serve()
+	return sftp.NewClientPipe(clientConn, clientConn)
+}
+
+func TestSyncDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarmgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "rules", "empty"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "prometheus.yml"), []byte("a: 1\r\nb: 2\r\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "rules", "node.rules"), []byte("same\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "rules", "image.png"), []byte("\x00\r\n"), 0644)
+
+	files, _, err := readSyncFiles(dir, true)
+	if err != nil || len(files) != 3 {
+		t.Fatal("Unexpected files:", files, err)
+	}
+	unchanged := ""
+	for _, f := range files {
+		if f.path == "rules/node.rules" {
+			unchanged = f.checksum
+		}
+	}
+
+	transport := &sftpTransport{
+		stubTransport: stubTransport{stdout: unchanged + "  ./rules/node.rules\nbad line\n"},
+		fs:            sftp.InMemHandler(),
+	}
+	client := Client("cluster", "")
+	client.HideStdout = true
+	client.transport = transport
+	res, err := client.SyncDir("10.0.0.1", dir, "/swarmprom", SyncOptions{Mode: 0777, Owner: "root:root", NormalizeLineEndings: true})
+	if err != nil || res.Uploaded != 2 || res.Skipped != 1 {
+		t.Fatal("Unexpected sync result:", res, err)
+	}
+
+	sftpClient, _ := transport.sftp(nil)
+	defer sftpClient.Close()
+	for file, expected := range map[string]string{
+		"/swarmprom/prometheus.yml":   "a: 1\nb: 2\n",
+		"/swarmprom/rules/image.png":  "\x00\r\n",
+		"/swarmprom/rules/node.rules": "",
+	} {
+		f, err := sftpClient.Open(file)
+		if expected == "" {
+			if err == nil {
+				t.Error("Unchanged file must not be uploaded:", file)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(file, err)
+		}
+		contents, _ := ioutil.ReadAll(f)
+		f.Close()
+		if string(contents) != expected {
+			t.Errorf("Unexpected %s contents: %q", file, contents)
+		}
+	}
+	if info, err := sftpClient.Stat("/swarmprom/rules/empty"); err != nil || !info.IsDir() {
+		t.Error("Empty dir must be created", err)
+	}
+
+	expected := []string{
+		"cd /swarmprom 2> /dev/null && find . -type f -exec sha256sum {} + ; true",
+		"sudo find /swarmprom -type f -exec chown root:root {} + && sudo find /swarmprom -type f -exec chmod 0777 {} +",
+	}
+	if strings.Join(transport.commands, "\n") != strings.Join(expected, "\n") {
+		t.Error("Unexpected commands:", transport.commands)
+	}
+}
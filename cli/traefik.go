@@ -10,6 +10,7 @@ package cli
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"html/template"
 	"io/ioutil"
@@ -19,7 +20,6 @@ import (
 
 	"github.com/spf13/cobra"
 	gc "github.com/untillpro/gochips"
-	"gopkg.in/yaml.v2"
 )
 
 const (
@@ -66,8 +66,10 @@ func DeployTraefik(traefikPass string) {
 		encrypted = encryptedFlag
 	}
 
-	if len(traefikPass) == 0 {
-		traefikPass = readPasswordPrompt(fmt.Sprintf("Specify [%s] password (access to Traefik dashboard)", clusterFile.TraefikUser))
+	if len(traefikPass) == 0 && len(clusterFile.TraefikBasicAuth) == 0 {
+		traefikPass = getCredential(credTraefikPassword, func() string {
+			return readPasswordPrompt(fmt.Sprintf("Specify [%s] password (access to Traefik dashboard)", clusterFile.TraefikUser))
+		})
 	}
 
 	gc.Info("Installing htpasswd")
@@ -102,12 +104,8 @@ func DeployTraefik(traefikPass string) {
 			nodes[i].Traefik = true
 		}
 	}
-	marshaledNode, err := yaml.Marshal(&nodes)
-	gc.ExitIfError(err)
-	nodesFilePath := filepath.Join(getWorkingDir(), nodesFileName)
-	err = ioutil.WriteFile(nodesFilePath, marshaledNode, 0600)
+	writeNodesYml(nodes)
 	gc.Info("Traefik deployed")
-	gc.ExitIfError(err)
 }
 
 // traefikCmd represents the traefik command
@@ -121,7 +119,7 @@ var traefikCmd = &cobra.Command{
 	}),
 }
 
-func storeTraefikConfigToConsul(clusterFile *clusterFile, host string, client *SSHClient) {
+func storeTraefikConfigToConsul(clusterFile *clusterFile, host string, client Executor) {
 	gc.Info("Traefik store config started")
 	traefikStoreConfig := executeTemplateToFile(filepath.Join(getSourcesDir(), traefikStoreConfigFileName), clusterFile)
 
@@ -132,7 +130,7 @@ func storeTraefikConfigToConsul(clusterFile *clusterFile, host string, client *S
 	gc.Info("Traefik configs stored in consul")
 }
 
-func deployConsul(nodes []node, clusterFile *clusterFile, host string, client *SSHClient) {
+func deployConsul(nodes []node, clusterFile *clusterFile, host string, client Executor) {
 	gc.Info("Consul deployment started")
 	var bootstrap uint8
 	for _, node := range nodes {
@@ -187,10 +185,9 @@ func executeTemplateToFile(filePath string, tmplExecutor interface{}) *bytes.Buf
 	return &tmplBuffer
 }
 
-func deployTraefik(clusterFile *clusterFile, host, traefikComposeName string, client *SSHClient, traefikPass string) {
+func deployTraefik(clusterFile *clusterFile, host, traefikComposeName string, client Executor, traefikPass string) {
 
-	clusterFile.TraefikBasicAuth = client.ExecOrExit(host, fmt.Sprintf("htpasswd -nbB %s \"%s\"", clusterFile.TraefikUser, traefikPass))
-	clusterFile.TraefikBasicAuth = strings.ReplaceAll(clusterFile.TraefikBasicAuth, "$", "\\$\\$")
+	clusterFile.TraefikBasicAuth = htpasswdEntry(client, host, clusterFile.TraefikUser, traefikPass, clusterFile.TraefikBasicAuth)
 
 	tmplBuffer := executeTemplateToFile(filepath.Join(getSourcesDir(), traefikComposeName), clusterFile)
 	gc.Info("traefik.yml modified")
@@ -199,7 +196,7 @@ func deployTraefik(clusterFile *clusterFile, host, traefikComposeName string, cl
 	client.ExecOrExit(host, "sudo docker stack deploy -c "+traefikFolderName+"traefik.yml traefik")
 }
 
-func deployTraefikSSL(clusterFile *clusterFile, host string, client *SSHClient, traefikPass string) {
+func deployTraefikSSL(clusterFile *clusterFile, host string, client Executor, traefikPass string) {
 	deployTraefik(clusterFile, host, traefikComposeFileName, client, traefikPass)
 	gc.Doing("Waiting for certs")
 	waitSuccessOrFailAfterTimer(host, "Server responded with a certificate", "Cert received",
@@ -209,26 +206,24 @@ func deployTraefikSSL(clusterFile *clusterFile, host string, client *SSHClient,
 }
 
 func waitSuccessOrFailAfterTimer(host, success, logSuccess, logFail, cmd string, timeBeforeFailInMinutes time.Duration,
-	client *SSHClient) {
-	timer := time.NewTimer(timeBeforeFailInMinutes * time.Minute)
-	doneChan := make(chan struct{})
-	go func() {
-		for true {
-			time.Sleep(10 * time.Second)
-			out := client.ExecOrExit(host, cmd)
-			if strings.Contains(out, success) {
-				doneChan <- struct{}{}
-				break
-			}
+	client Executor) {
+	ctx, cancel := context.WithTimeout(commandContext(), timeBeforeFailInMinutes*time.Minute)
+	defer cancel()
+	for {
+		select {
+		case <-ctx.Done():
+			gc.ExitIfError(commandContext().Err())
+			gc.Fatal(logFail)
+		case <-time.After(10 * time.Second):
+		}
+		out, err := client.ExecContext(ctx, host, cmd)
+		if ctx.Err() != nil {
+			continue
+		}
+		gc.ExitIfError(err)
+		if strings.Contains(out, success) {
+			gc.Info(logSuccess)
+			return
 		}
-	}()
-	select {
-	case <-doneChan:
-		gc.Info(logSuccess)
-	case <-timer.C:
-		close(doneChan)
-		gc.Fatal(logFail)
 	}
-	close(doneChan)
-	timer.Stop()
 }
@@ -0,0 +1,179 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	gc "github.com/untillpro/gochips"
+)
+
+const transcriptExt = ".log"
+
+var logsHostArg string
+
+// transcripts keep everything done on every host during the command, one file per host.
+// Transcript dir is created on first write, so commands which don't reach hosts don't leave empty dirs
+var transcripts = struct {
+	sync.Mutex
+	dir    string
+	byHost map[string]*os.File
+}{byHost: make(map[string]*os.File)}
+
+// openTranscripts makes further transcripts written to dir, transcripts of the previous dir are closed
+func openTranscripts(dir string) {
+	closeTranscripts()
+	transcripts.Lock()
+	defer transcripts.Unlock()
+	transcripts.dir = dir
+}
+
+func closeTranscripts() {
+	transcripts.Lock()
+	defer transcripts.Unlock()
+	for host, f := range transcripts.byHost {
+		f.Close()
+		delete(transcripts.byHost, host)
+	}
+	transcripts.dir = ""
+}
+
+// transcriptFileName keeps host name usable as file name, e.g. IPv6 addresses on Windows
+func transcriptFileName(host string) string {
+	return strings.NewReplacer(":", "_", "/", "_", "\\", "_").Replace(host) + transcriptExt
+}
+
+func writeTranscript(host, text string) {
+	transcripts.Lock()
+	defer transcripts.Unlock()
+	if len(transcripts.dir) == 0 {
+		return
+	}
+	f, ok := transcripts.byHost[host]
+	if !ok {
+		if err := os.MkdirAll(transcripts.dir, 0700); err != nil {
+			gc.Verbose("Unable to create transcript dir:", err)
+			return
+		}
+		var err error
+		f, err = os.OpenFile(filepath.Join(transcripts.dir, transcriptFileName(host)), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			gc.Verbose("Unable to create transcript:", err)
+			return
+		}
+		transcripts.byHost[host] = f
+	}
+	f.WriteString(text)
+}
+
+// transcriptEntry formats executed command, masked input and output are replaced by **(masked)**
+func transcriptEntry(user string, res *ExecResult, err error, maskInput, maskOutput bool) string {
+	var buf bytes.Buffer
+	start := time.Now()
+	if res != nil {
+		start = start.Add(-res.Duration)
+	}
+	fmt.Fprintf(&buf, "=== %s %s\n", start.Format("20060102 15:04:05.000"), user)
+	command, stdout, stderr := "", "", ""
+	if res != nil {
+		command, stdout, stderr = res.Command, res.Stdout, res.Stderr
+	}
+	if maskInput {
+		command = maskedValue
+	}
+	if maskOutput {
+		stdout = maskedValue
+	}
+	fmt.Fprintf(&buf, "$ %s\n", command)
+	for _, out := range []struct{ name, text string }{{"stdout", stdout}, {"stderr", stderr}} {
+		if len(out.text) > 0 {
+			fmt.Fprintf(&buf, "--- %s\n%s", out.name, out.text)
+			if !strings.HasSuffix(out.text, "\n") {
+				buf.WriteString("\n")
+			}
+		}
+	}
+	switch {
+	case err != nil:
+		fmt.Fprintf(&buf, "=== failed: %v\n\n", err)
+	default:
+		fmt.Fprintf(&buf, "=== exit code %d in %v\n\n", res.ExitCode, res.Duration)
+	}
+	return buf.String()
+}
+
+// transcriptNote formats file transfer or other action which has no command output
+func transcriptNote(user, action string, err error) string {
+	result := "ok"
+	if err != nil {
+		result = "failed: " + err.Error()
+	}
+	return fmt.Sprintf("=== %s %s\n# %s\n=== %s\n\n", time.Now().Format("20060102 15:04:05.000"), user, action, result)
+}
+
+// lastTranscriptDir returns the newest transcript dir in logsDir, dir names start with timestamp
+func lastTranscriptDir(logsDir string) (string, error) {
+	entries, err := ioutil.ReadDir(logsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	dirs := make([]string, 0)
+	for _, e := range entries {
+		if e.IsDir() {
+			dirs = append(dirs, e.Name())
+		}
+	}
+	if len(dirs) == 0 {
+		return "", errors.New("no transcripts found in " + logsDir)
+	}
+	sort.Strings(dirs)
+	return filepath.Join(logsDir, dirs[len(dirs)-1]), nil
+}
+
+var logsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Shows transcripts of commands run on hosts",
+	Long:  `Every command which reaches hosts writes transcript with one file per host to logs/<time>-<command> folder`,
+}
+
+var logsLastCmd = &cobra.Command{
+	Use:   "last [--host alias]",
+	Short: "Shows transcripts of the last command which reached hosts",
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, err := lastTranscriptDir(filepath.Join(getWorkingDir(), "logs"))
+		gc.ExitIfError(err)
+		files, err := filepath.Glob(filepath.Join(dir, "*"+transcriptExt))
+		gc.ExitIfError(err)
+		if len(logsHostArg) > 0 {
+			host := logsHostArg
+			for _, n := range getNodesFromYml(getWorkingDir()) {
+				if n.Alias == logsHostArg {
+					host = n.Host
+				}
+			}
+			files = []string{filepath.Join(dir, transcriptFileName(host))}
+		}
+		fmt.Println("# " + dir)
+		for _, file := range files {
+			content, err := ioutil.ReadFile(file)
+			gc.ExitIfError(err, "No transcript for "+logsHostArg+" in "+dir)
+			fmt.Printf("\n## %s\n\n%s", filepath.Base(file), content)
+		}
+	},
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTranscripts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarmgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := lastTranscriptDir(dir); err == nil {
+		t.Error("No transcripts must be reported")
+	}
+
+	first := filepath.Join(dir, "20190101-100000-docker")
+	last := filepath.Join(dir, "20190101-100500-swarm")
+	openTranscripts(first)
+	writeTranscript("10.0.0.1", "first\n")
+	openTranscripts(last)
+	defer closeTranscripts()
+
+	client := Client("cluster", "")
+	client.HideStdout = true
+	client.transport = &stubTransport{stdout: "secret\n"}
+	client.Run("10.0.0.1", "!echo password | chpasswd")
+	client.Run("10.0.0.1", "$docker swarm join-token worker")
+	client.transport = &stubTransport{stdout: "partial", stderr: "E: broken", err: &remoteExitError{100, errors.New("exit status 100")}}
+	client.Run("10.0.0.2", "sudo apt-get install -y docker-ce")
+	client.transport = &stubTransport{err: errors.New("dial tcp: timeout")}
+	client.Copy("fe80::1", 5, 0644, "a", strings.NewReader("hello"), "/tmp/a")
+	closeTranscripts()
+
+	found, err := lastTranscriptDir(dir)
+	if err != nil || found != last {
+		t.Fatal("Unexpected last transcript dir:", found, err)
+	}
+	for file, expected := range map[string][]string{
+		"10.0.0.1.log": {"cluster\n$ **(masked)**\n--- stdout\nsecret\n=== exit code 0", "$ docker swarm join-token worker\n--- stdout\n**(masked)**\n=== exit code 0"},
+		"10.0.0.2.log": {"$ sudo apt-get install -y docker-ce\n--- stdout\npartial\n--- stderr\nE: broken\n=== exit code 100"},
+		"fe80__1.log":  {"# copy 5 bytes with mode 0644 to /tmp/a\n=== failed: dial tcp: timeout"},
+	} {
+		content, err := ioutil.ReadFile(filepath.Join(last, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range expected {
+			if !strings.Contains(string(content), e) {
+				t.Errorf("%s must contain %q, got:\n%s", file, e, content)
+			}
+		}
+		if strings.Contains(string(content), "password") {
+			t.Error("Masked input must not be written to transcript")
+		}
+	}
+}
@@ -0,0 +1,291 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/spf13/cobra"
+	gc "github.com/untillpro/gochips"
+	"golang.org/x/crypto/ssh"
+)
+
+// usersGroup marks accounts managed by swarmgo, only members of the group are removed from nodes
+const usersGroup = "swarmgo-users"
+
+var userKeyArg string
+var userSudoArg bool
+
+var userNameRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+// operator is a personal account of the team member, created on all nodes
+// Sudo password is kept in the creds store, not in swarmgo-config.yml which is usually committed
+type operator struct {
+	Name      string `yaml:"Name"`
+	PublicKey string `yaml:"PublicKey"` // authorized_keys line
+	Sudo      bool   `yaml:"Sudo,omitempty"`
+}
+
+// operatorPasswordCred is the name of the credential which keeps sudo password of the user
+func operatorPasswordCred(name string) string {
+	return "user-" + name + "-password"
+}
+
+// hasPassword returns true if the user gets sudo password, otherwise the user logs in by key only
+func hasPassword(u operator, sudoWithPassword bool) bool {
+	return u.Sudo && sudoWithPassword
+}
+
+// withOperator returns users with given one added or replaced
+func withOperator(users []operator, user operator) []operator {
+	res := make([]operator, 0, len(users)+1)
+	for _, u := range users {
+		if u.Name != user.Name {
+			res = append(res, u)
+		}
+	}
+	res = append(res, user)
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// withoutOperator returns users without given one and whether it was declared
+func withoutOperator(users []operator, name string) ([]operator, bool) {
+	res := make([]operator, 0, len(users))
+	for _, u := range users {
+		if u.Name != name {
+			res = append(res, u)
+		}
+	}
+	return res, len(res) != len(users)
+}
+
+func checkUserName(clusterFile *clusterFile, name string) error {
+	if !userNameRegexp.MatchString(name) {
+		return fmt.Errorf("wrong user name %s, must match %s", name, userNameRegexp.String())
+	}
+	if name == "root" || name == clusterFile.ClusterUserName || name == clusterFile.RootUserName {
+		return fmt.Errorf("%s is reserved", name)
+	}
+	return nil
+}
+
+// usersScript creates declared users with their keys and removes managed users which are not declared anymore.
+// If sudoWithPassword is true sudo users get normal sudo permissions and their passwords are read from stdin as
+// <name>:<password> lines, hashed by chpasswd on the node. Otherwise sudo users get NOPASSWD ones
+func usersScript(users []operator, sudoWithPassword bool) string {
+	declared := make([]string, 0, len(users))
+	lines := []string{
+		"set -e",
+		"sudo groupadd -f " + usersGroup,
+	}
+	withPasswords := false
+	for _, u := range users {
+		withPasswords = withPasswords || hasPassword(u, sudoWithPassword)
+	}
+	if withPasswords {
+		// Passwords are read first, so commands below can't consume them
+		lines = append(lines, "passwords=$(cat)")
+	}
+	for _, u := range users {
+		declared = append(declared, u.Name)
+		home := "/home/" + u.Name
+		sudoers := "/etc/sudoers.d/swarmgo-" + u.Name
+		lines = append(lines,
+			fmt.Sprintf("if id -u %[1]s > /dev/null 2>&1; then id -nG %[1]s | grep -qw %[2]s || { echo \"%[1]s exists and is not managed by swarmgo\" >&2; exit 1; }; "+
+				"else sudo useradd -m -s /bin/bash -G %[2]s %[1]s; fi", u.Name, usersGroup),
+			fmt.Sprintf("sudo install -d -m 700 -o %[1]s -g %[1]s %[2]s/.ssh", u.Name, home),
+			fmt.Sprintf("echo %s | sudo tee %s/.ssh/authorized_keys > /dev/null", shellquote.Join(u.PublicKey), home),
+			fmt.Sprintf("sudo chown %[1]s:%[1]s %[2]s/.ssh/authorized_keys && sudo chmod 600 %[2]s/.ssh/authorized_keys", u.Name, home),
+		)
+		// Password is not locked, otherwise sshd may refuse key login
+		rule := "NOPASSWD: ALL"
+		if hasPassword(u, sudoWithPassword) {
+			rule = "ALL"
+		} else {
+			lines = append(lines, fmt.Sprintf("echo '%s:*' | sudo chpasswd -e", u.Name))
+		}
+		if u.Sudo {
+			lines = append(lines, fmt.Sprintf("echo '%[1]s ALL=(ALL:ALL) %[3]s' | sudo tee %[2]s.tmp > /dev/null && "+
+				"sudo chmod 440 %[2]s.tmp && sudo visudo -cf %[2]s.tmp > /dev/null && sudo mv %[2]s.tmp %[2]s", u.Name, sudoers, rule))
+		} else {
+			lines = append(lines, "sudo rm -f "+sudoers)
+		}
+	}
+	if withPasswords {
+		// printf is a builtin, so passwords don't appear in the process list
+		lines = append(lines, `printf '%s\n' "$passwords" | sudo chpasswd`)
+	}
+	lines = append(lines,
+		fmt.Sprintf("for u in $(getent group %s | cut -d: -f4 | tr ',' ' '); do", usersGroup),
+		fmt.Sprintf("  case \" %s \" in *\" $u \"*) ;; *)", strings.Join(declared, " ")),
+		"    echo \"Removing $u\"; sudo pkill -KILL -u \"$u\" || true; sudo userdel -r \"$u\" 2> /dev/null || sudo userdel \"$u\"; sudo rm -f \"/etc/sudoers.d/swarmgo-$u\";;",
+		"  esac",
+		"done",
+	)
+	return strings.Join(lines, "\n")
+}
+
+// usersPasswords returns input of usersScript, passwords are taken from the creds store
+func usersPasswords(users []operator, sudoWithPassword bool, password func(name string) (string, bool)) (string, error) {
+	var res strings.Builder
+	for _, u := range users {
+		if !hasPassword(u, sudoWithPassword) {
+			continue
+		}
+		value, ok := password(u.Name)
+		if !ok {
+			return "", fmt.Errorf("user %s has no sudo password in %s, run `swarmgo users add %s --key <file.pub> --sudo` to set it", u.Name, credsFileName, u.Name)
+		}
+		res.WriteString(u.Name + ":" + value + "\n")
+	}
+	return res.String(), nil
+}
+
+// readOperatorPassword asks for sudo password of the user and keeps it in the creds store, empty input keeps stored password
+func readOperatorPassword(name string) {
+	_, stored := lookupCredential(operatorPasswordCred(name))
+	prompt := "Specify sudo password for " + name
+	if stored {
+		prompt += " (empty to keep current)"
+	}
+	password := readPasswordPrompt(prompt)
+	if len(password) == 0 && stored {
+		return
+	}
+	gc.ExitIfFalse(len(password) > 0, "Sudo password must not be empty")
+	gc.ExitIfFalse(!strings.ContainsAny(password, "\r\n"), "Sudo password must not contain line breaks")
+	gc.ExitIfFalse(readPasswordPrompt("Repeat password") == password, "Passwords do not match")
+	setCredential(operatorPasswordCred(name), password)
+}
+
+// SyncUsers reconciles users declared in swarmgo-config.yml on all nodes from nodes.yml
+func SyncUsers(clusterFile *clusterFile) {
+	nodes := getNodesFromYml(getWorkingDir())
+	gc.ExitIfFalse(len(nodes) > 0, "Can't find nodes from nodes.yml. Add some nodes first!")
+	passwords, err := usersPasswords(clusterFile.Users, clusterFile.SudoWithPassword, func(name string) (string, bool) {
+		return lookupCredential(operatorPasswordCred(name))
+	})
+	gc.ExitIfError(err)
+	client := getSSHClient(clusterFile)
+	// Script is masked, passwords are given on stdin, so neither gets to logs and transcripts
+	script := "!" + usersScript(clusterFile.Users, clusterFile.SudoWithPassword)
+	errs := make(chan error)
+	for _, n := range nodes {
+		go func(n node) {
+			logWithPrefix(n.Host, fmt.Sprintf("Reconciling %d user(s)...", len(clusterFile.Users)))
+			errs <- defaultRetryPolicy.do(n.Host, func() error {
+				ctx, cancel := timeoutContext(defaultCommandTimeout)
+				defer cancel()
+				return stepError(client.RunInput(ctx, n.Host, script, strings.NewReader(passwords)))
+			})
+		}(n)
+	}
+	errMsgs := make([]string, 0)
+	for range nodes {
+		if err := <-errs; err != nil {
+			errMsgs = append(errMsgs, err.Error())
+		}
+	}
+	for _, errMsg := range errMsgs {
+		gc.Info(errMsg)
+	}
+	gc.ExitIfFalse(len(errMsgs) == 0, "Failed to reconcile users on some node(s), run `swarmgo users sync` to retry")
+	gc.Info("Users reconciled on all nodes")
+}
+
+var usersCmd = &cobra.Command{
+	Use:   "users",
+	Short: "Manage personal accounts of team members on all nodes",
+	Long:  `Users are declared in swarmgo-config.yml and reconciled on every node from nodes.yml, each user logs in by own key`,
+}
+
+var usersAddCmd = &cobra.Command{
+	Use:   "add <name> --key file.pub [--sudo]",
+	Short: "Adds user or replaces its key on all nodes",
+	Long: `With --sudo user is allowed to run sudo. If SudoWithPassword is true in swarmgo-config.yml sudo password of the user is asked,
+kept in ` + credsFileName + ` as user-<name>-password and set on all nodes, otherwise user runs sudo without password`,
+	Args: cobra.ExactArgs(1),
+	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
+		clusterFile := unmarshalClusterYmlForUpdate()
+		gc.ExitIfError(checkUserName(clusterFile, args[0]))
+		gc.ExitIfFalse(len(userKeyArg) > 0, "Public key must be specified by --key option")
+		keyBytes, err := ioutil.ReadFile(userKeyArg)
+		gc.ExitIfError(err, "Unable to read public key from "+userKeyArg)
+		publicKey, comment, _, _, err := ssh.ParseAuthorizedKey(keyBytes)
+		gc.ExitIfError(err, "Wrong public key in "+userKeyArg)
+		keyLine := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey)))
+		if len(comment) > 0 {
+			keyLine += " " + comment
+		}
+
+		user := operator{Name: args[0], PublicKey: keyLine, Sudo: userSudoArg}
+		if hasPassword(user, clusterFile.SudoWithPassword) {
+			readOperatorPassword(args[0])
+		}
+		checkSSHAgent()
+		clusterFile.Users = withOperator(clusterFile.Users, user)
+		marshalClusterYml(clusterFile)
+		gc.Info(fmt.Sprintf("User %s with key %s declared in %s", args[0], ssh.FingerprintSHA256(publicKey), swarmgoConfigFileName))
+		SyncUsers(clusterFile)
+	}),
+}
+
+var usersRmCmd = &cobra.Command{
+	Use:   "rm <name>",
+	Short: "Removes user from all nodes",
+	Args:  cobra.ExactArgs(1),
+	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
+		clusterFile := unmarshalClusterYmlForUpdate()
+		var declared bool
+		clusterFile.Users, declared = withoutOperator(clusterFile.Users, args[0])
+		gc.ExitIfFalse(declared, "User isn't declared in "+swarmgoConfigFileName+": "+args[0])
+
+		checkSSHAgent()
+		marshalClusterYml(clusterFile)
+		gc.Info(fmt.Sprintf("User %s removed from %s", args[0], swarmgoConfigFileName))
+		SyncUsers(clusterFile)
+	}),
+}
+
+var usersLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "Lists users declared in swarmgo-config.yml",
+	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
+		clusterFile := unmarshalClusterYml()
+		if len(clusterFile.Users) == 0 {
+			gc.Info("No users declared")
+		}
+		for _, u := range clusterFile.Users {
+			fingerprint := "wrong key"
+			if publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(u.PublicKey)); err == nil {
+				fingerprint = ssh.FingerprintSHA256(publicKey)
+			}
+			sudo := ""
+			if u.Sudo {
+				sudo = " sudo"
+			}
+			fmt.Printf("%s %s%s\n", u.Name, fingerprint, sudo)
+		}
+	}),
+}
+
+var usersSyncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Reconciles declared users on all nodes, e.g. after nodes are added",
+	Run: loggedCmd(func(cmd *cobra.Command, args []string) {
+		checkSSHAgent()
+		SyncUsers(unmarshalClusterYml())
+	}),
+}
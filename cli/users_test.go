@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2018-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ *
+ */
+
+package cli
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestOperators(t *testing.T) {
+	users := withOperator(nil, operator{Name: "bob", PublicKey: "ssh-ed25519 AAAA1 bob"})
+	users = withOperator(users, operator{Name: "alice", PublicKey: "ssh-ed25519 AAAA2 alice"})
+	users = withOperator(users, operator{Name: "bob", PublicKey: "ssh-ed25519 AAAA3 bob", Sudo: true})
+	if len(users) != 2 || users[0].Name != "alice" || users[1].PublicKey != "ssh-ed25519 AAAA3 bob" || !users[1].Sudo {
+		t.Error("Unexpected users:", users)
+	}
+	users, declared := withoutOperator(users, "alice")
+	if !declared || len(users) != 1 || users[0].Name != "bob" {
+		t.Error("Unexpected users after removal:", users)
+	}
+	if _, declared := withoutOperator(users, "carol"); declared {
+		t.Error("Undeclared user can't be removed")
+	}
+
+	clusterFile := &clusterFile{ClusterUserName: "cluster", RootUserName: "root"}
+	for _, name := range []string{"cluster", "root", "Bob", "1bob", "bob;rm", ""} {
+		if checkUserName(clusterFile, name) == nil {
+			t.Error("Name must be rejected:", name)
+		}
+	}
+	if err := checkUserName(clusterFile, "bob-smith"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUsersScript(t *testing.T) {
+	users := []operator{{Name: "alice", PublicKey: "ssh-ed25519 AAAA alice's key", Sudo: true}, {Name: "bob", PublicKey: "ssh-rsa BBBB"}}
+	script := usersScript(users, false)
+	if out, err := exec.Command("sh", "-n", "-c", script).CombinedOutput(); err != nil {
+		t.Fatal("Wrong script syntax:", string(out), err, script)
+	}
+	if !strings.Contains(script, `case " alice bob " in`) || !strings.Contains(script, "echo 'alice ALL=(ALL:ALL) NOPASSWD: ALL' | sudo tee /etc/sudoers.d/swarmgo-alice.tmp") ||
+		!strings.Contains(script, "echo 'alice:*' | sudo chpasswd -e") || !strings.Contains(script, "sudo rm -f /etc/sudoers.d/swarmgo-bob") ||
+		strings.Contains(script, "$(cat)") {
+		t.Error("Unexpected script:", script)
+	}
+
+	script = usersScript(users, true)
+	if !strings.Contains(script, "echo 'alice ALL=(ALL:ALL) ALL' | sudo tee /etc/sudoers.d/swarmgo-alice.tmp") ||
+		strings.Contains(script, "alice:*") || !strings.Contains(script, "echo 'bob:*' | sudo chpasswd -e") {
+		t.Error("Sudo with password expected:", script)
+	}
+	if out, err := exec.Command("sh", "-n", "-c", usersScript(nil, true)).CombinedOutput(); err != nil {
+		t.Error("Wrong script syntax without users:", string(out), err)
+	}
+
+	// Passwords given on stdin must reach chpasswd unchanged
+	passwordLines := make([]string, 0)
+	for _, line := range strings.Split(script, "\n") {
+		if strings.Contains(line, "passwords") {
+			passwordLines = append(passwordLines, strings.Replace(line, "sudo chpasswd", "cat", 1))
+		}
+	}
+	cmd := exec.Command("sh", "-c", strings.Join(passwordLines, "\n"))
+	cmd.Stdin = strings.NewReader("alice:p@ss word:1\n")
+	if out, err := cmd.CombinedOutput(); err != nil || string(out) != "alice:p@ss word:1\n" {
+		t.Error("Unexpected chpasswd input:", string(out), err, passwordLines)
+	}
+}
+
+func TestUsersPasswords(t *testing.T) {
+	users := []operator{{Name: "alice", Sudo: true}, {Name: "bob"}}
+	stored := map[string]string{"alice": "secret"}
+	password := func(name string) (string, bool) {
+		value, ok := stored[name]
+		return value, ok
+	}
+	if input, err := usersPasswords(users, true, password); err != nil || input != "alice:secret\n" {
+		t.Error("Unexpected input:", input, err)
+	}
+	if input, err := usersPasswords(users, false, password); err != nil || len(input) != 0 {
+		t.Error("No passwords expected without SudoWithPassword:", input, err)
+	}
+	delete(stored, "alice")
+	if _, err := usersPasswords(users, true, password); err == nil || !strings.Contains(err.Error(), "alice") {
+		t.Error("Missing password must be reported:", err)
+	}
+	if name := operatorPasswordCred("alice"); !credNameRegexp.MatchString(name) {
+		t.Error("Wrong credential name:", name)
+	}
+}
@@ -1,8 +1,9 @@
 #!/bin/bash
 
 # $1: cluster user name
-# $2: temp password
-# $3: public key file content\
+# $2: public key file content
+# $3: "nopasswd" to allow sudo without password
+# Password is read from the first line of stdin, so it does not appear in the command line
 
 if [ $# -eq 0 ]
   then
@@ -12,14 +13,19 @@ fi
 
 set -e
 
+IFS= read -r password
+
 echo "Adding user $1"
 sudo adduser --disabled-password --gecos "" $1
 
-sudo echo "$1:$2" | sudo chpasswd
+printf "%s:%s\n" "$1" "$password" | sudo chpasswd
 
 sudo usermod -aG sudo $1
 
-sudo echo "${1} ALL=(ALL:ALL) NOPASSWD: ALL" | sudo EDITOR="tee -a" visudo
+if [ "$3" = "nopasswd" ]
+  then
+    sudo echo "${1} ALL=(ALL:ALL) NOPASSWD: ALL" | sudo EDITOR="tee -a" visudo
+fi
 
 passwd -l root
 
@@ -30,7 +36,7 @@ chmod 700 /home/$1/.ssh
 touch /home/$1/.ssh/authorized_keys
 chown $1 /home/$1/.ssh/authorized_keys
 chmod 600 /home/$1/.ssh/authorized_keys
-echo $3 | tee /home/$1/.ssh/authorized_keys
+echo $2 | tee /home/$1/.ssh/authorized_keys
 
 sed -i "s/#PasswordAuthentication yes/PasswordAuthentication no/g" /etc/ssh/sshd_config
 sudo service ssh restart
\ No newline at end of file